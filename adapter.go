@@ -0,0 +1,129 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// visitGoMetric adapts a go-metrics value, registered through Register or GetOrRegister, to the matching
+// Visitor method. Counters are visited as gauges of their count, and healthchecks as gauges which are 1
+// when healthy and 0 otherwise. Healthchecks aren't run by the visit, see RunHealthchecks.
+func visitGoMetric(name string, value any, visitor Visitor) {
+	switch metric := value.(type) {
+	case metrics.Counter:
+		visitor.VisitGauge(name, &counterAdapter{Counter: metric})
+	case metrics.Gauge:
+		visitor.VisitGauge(name, &gaugeAdapter{Gauge: metric})
+	case metrics.GaugeFloat64:
+		visitor.VisitGaugeFloat64(name, &gaugeFloat64Adapter{GaugeFloat64: metric})
+	case metrics.Meter:
+		visitor.VisitMeter(name, &meterAdapter{Meter: metric})
+	case metrics.Histogram:
+		visitor.VisitHistogram(name, &histogramAdapter{Histogram: metric.Snapshot()})
+	case metrics.Timer:
+		visitor.VisitTimer(name, &timerAdapter{Timer: metric.Snapshot()})
+	case metrics.Healthcheck:
+		visitor.VisitGauge(name, &healthcheckAdapter{Healthcheck: metric})
+	default:
+		slog.Error("unsupported metric type", "type", reflect.TypeOf(value))
+	}
+}
+
+type counterAdapter struct {
+	metrics.Counter
+}
+
+func (self *counterAdapter) Value() int64 {
+	return self.Count()
+}
+
+func (self *counterAdapter) Update(value int64) {
+	self.Clear()
+	self.Inc(value)
+}
+
+func (self *counterAdapter) Unit() Unit { return UnitNone }
+func (self *counterAdapter) Dispose()   {}
+
+type gaugeAdapter struct {
+	metrics.Gauge
+}
+
+func (self *gaugeAdapter) Unit() Unit { return UnitNone }
+func (self *gaugeAdapter) Dispose()   {}
+
+type gaugeFloat64Adapter struct {
+	metrics.GaugeFloat64
+}
+
+func (self *gaugeFloat64Adapter) Unit() Unit { return UnitNone }
+func (self *gaugeFloat64Adapter) Dispose()   {}
+
+type meterAdapter struct {
+	metrics.Meter
+}
+
+func (self *meterAdapter) Unit() Unit { return UnitNone }
+func (self *meterAdapter) Dispose()   {}
+
+func (self *meterAdapter) Rate(window time.Duration) float64 { return rateOf(self.Meter, window) }
+func (self *meterAdapter) RateWindows() []time.Duration      { return rateWindowsOf(self.Meter) }
+
+type histogramAdapter struct {
+	metrics.Histogram
+}
+
+func (self *histogramAdapter) Unit() Unit { return UnitNone }
+func (self *histogramAdapter) Dispose()   {}
+
+func (self *histogramAdapter) CreateSnapshot() Histogram {
+	return &histogramAdapter{Histogram: self.Snapshot()}
+}
+
+type timerAdapter struct {
+	metrics.Timer
+}
+
+func (self *timerAdapter) Unit() Unit { return UnitNanoseconds }
+func (self *timerAdapter) Dispose()   {}
+
+func (self *timerAdapter) Rate(window time.Duration) float64 { return rateOf(self.Timer, window) }
+func (self *timerAdapter) RateWindows() []time.Duration      { return rateWindowsOf(self.Timer) }
+
+func (self *timerAdapter) CreateSnapshot() Timer {
+	return &timerAdapter{Timer: self.Snapshot()}
+}
+
+type healthcheckAdapter struct {
+	metrics.Healthcheck
+}
+
+func (self *healthcheckAdapter) Value() int64 {
+	if self.Error() == nil {
+		return 1
+	}
+	return 0
+}
+
+func (self *healthcheckAdapter) Update(int64) {}
+func (self *healthcheckAdapter) Unit() Unit   { return UnitNone }
+func (self *healthcheckAdapter) Dispose()     {}
@@ -0,0 +1,110 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// MetricNameCardinality is the name of the gauge which reports the number of metrics in a registry with
+// cardinality limits
+const MetricNameCardinality = "metrics.cardinality"
+
+// CardinalityLimit caps the number of live metrics in a registry, or the number whose names start with a
+// given prefix
+type CardinalityLimit struct {
+	// Prefix restricts the limit to metrics whose names start with it. An empty prefix limits the whole registry
+	Prefix string
+	// Max is the number of live metrics allowed. Once reached, metrics with new names are redirected or rejected
+	Max int
+	// OverflowName, if set, is the name metrics with new names are redirected to once the limit has been
+	// reached, for example "link.__overflow__". All metrics redirected to it share it, so it should only be
+	// used for prefixes holding a single type of metric. If not set, creating the metric fails with a
+	// CardinalityLimitError
+	OverflowName string
+}
+
+// WithCardinalityLimit caps the number of live metrics in the registry. It may be given several times, for
+// example once for the whole registry and once for each prefix which holds per-entity metrics. Limits are
+// checked when a metric with a new name is created, so concurrent creation may briefly exceed them.
+// Creating an overflow metric is always allowed. Rejected metrics are handled by the registry's
+// ErrorPolicy, so registries which reject metrics will usually want ErrorPolicyLogAndNoop.
+// When limits are configured, the number of metrics in the registry is reported by the
+// MetricNameCardinality gauge, which doesn't count itself or count towards any limit.
+func WithCardinalityLimit(limit CardinalityLimit) RegistryOption {
+	return func(registry *registryImpl) {
+		registry.cardinalityLimits = append(registry.cardinalityLimits, &cardinalityLimit{CardinalityLimit: limit})
+	}
+}
+
+type cardinalityLimit struct {
+	CardinalityLimit
+	count atomic.Int64
+}
+
+// applies returns true if the limit counts the named metric. The MetricNameCardinality gauge is never
+// counted, so it doesn't take up a slot of the limits it reports on
+func (self *cardinalityLimit) applies(name string) bool {
+	return name != MetricNameCardinality && strings.HasPrefix(name, self.Prefix)
+}
+
+func (registry *registryImpl) startCardinalityTracking() {
+	registry.FuncGauge(MetricNameCardinality, func() int64 {
+		count := int64(registry.metricMap.Count())
+		if registry.metricMap.Has(MetricNameCardinality) {
+			count--
+		}
+		return count
+	})
+}
+
+// exceededCardinalityLimit returns the limit which prevents a metric with the given name from being
+// created, or nil if it may be created
+func (registry *registryImpl) exceededCardinalityLimit(name string) *cardinalityLimit {
+	if len(registry.cardinalityLimits) == 0 || registry.metricMap.Has(name) {
+		return nil
+	}
+	for _, limit := range registry.cardinalityLimits {
+		if limit.applies(name) && limit.count.Load() >= int64(limit.Max) {
+			return limit
+		}
+	}
+	return nil
+}
+
+// applyCardinalityLimits returns the name a requested metric should be stored under, which is the overflow
+// name if the requested name would exceed a limit
+func (registry *registryImpl) applyCardinalityLimits(name string) (string, error) {
+	limit := registry.exceededCardinalityLimit(name)
+	if limit == nil {
+		return name, nil
+	}
+	if limit.OverflowName == "" {
+		return "", newCardinalityLimitError(name, limit)
+	}
+	return limit.OverflowName, nil
+}
+
+// updateCardinality adjusts the counts of the limits which apply to the named metric
+func (registry *registryImpl) updateCardinality(name string, delta int64) {
+	for _, limit := range registry.cardinalityLimits {
+		if limit.applies(name) {
+			limit.count.Add(delta)
+		}
+	}
+}
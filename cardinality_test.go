@@ -0,0 +1,101 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardinalityLimitOverflow(t *testing.T) {
+	registry := NewRegistry("test", nil, WithCardinalityLimit(CardinalityLimit{
+		Prefix:       "link.",
+		Max:          2,
+		OverflowName: "link.__overflow__",
+	}))
+
+	registry.Meter("link.a").Mark(1)
+	registry.Meter("link.b").Mark(1)
+	overflow := registry.Meter("link.c")
+	overflow.Mark(5)
+	registry.Meter("link.d").Mark(5)
+
+	require.False(t, registry.IsValidMetric("link.c"))
+	require.Equal(t, int64(10), registry.GetMeter("link.__overflow__").Count())
+
+	// existing names are still handed out, and other prefixes aren't limited
+	require.Equal(t, int64(1), registry.Meter("link.a").Count())
+	registry.Meter("other")
+	require.Equal(t, int64(4), registry.GetGauge(MetricNameCardinality).Value())
+
+	// disposing metrics makes room for new names. The overflow metric counts towards the limit
+	registry.Unregister("link.b")
+	registry.Meter("link.f")
+	require.False(t, registry.IsValidMetric("link.f"))
+	registry.Unregister("link.__overflow__")
+	registry.Meter("link.e")
+	require.True(t, registry.IsValidMetric("link.e"))
+}
+
+func TestCardinalityLimitReject(t *testing.T) {
+	registry := NewRegistry("test", nil, WithCardinalityLimit(CardinalityLimit{Max: 1}))
+
+	// the cardinality gauge doesn't take up a slot
+	gauge := registry.Gauge("gauge")
+	_, err := registry.TryHistogram("histogram")
+	require.ErrorIs(t, err, ErrCardinalityLimitExceeded)
+
+	var limitErr *CardinalityLimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, "histogram", limitErr.Name)
+	require.Equal(t, 1, limitErr.Max)
+
+	require.ErrorIs(t, registry.Register("custom", &stringSet{}), ErrCardinalityLimitExceeded)
+	require.Panics(t, func() { registry.(*registryImpl).GetOrRegister("custom", &stringSet{}) })
+	require.False(t, registry.IsValidMetric("custom"))
+	require.Panics(t, func() { registry.Timer("timer") })
+
+	gauge.Dispose()
+	timer, err := registry.TryTimer("timer")
+	require.NoError(t, err)
+	require.NotNil(t, timer)
+}
+
+func TestCardinalityLimitGetOrRegister(t *testing.T) {
+	registry := NewRegistry("test", nil, WithErrorPolicy(ErrorPolicyLogAndNoop), WithCardinalityLimit(CardinalityLimit{
+		Prefix:       "link.",
+		Max:          1,
+		OverflowName: "link.__overflow__",
+	}), WithCardinalityLimit(CardinalityLimit{Prefix: "set.", Max: 1})).(*registryImpl)
+
+	first := &stringSet{}
+	require.Same(t, first, registry.GetOrRegister("link.a", first))
+	require.Same(t, first, registry.GetOrRegister("link.a", &stringSet{}))
+
+	overflow := &stringSet{}
+	require.Same(t, overflow, registry.GetOrRegister("link.b", overflow))
+	require.Same(t, overflow, registry.GetOrRegister("link.c", &stringSet{}))
+	require.False(t, registry.IsValidMetric("link.b"))
+	require.True(t, registry.IsValidMetric("link.__overflow__"))
+
+	registry.GetOrRegister("set.a", &stringSet{})
+	rejected := &stringSet{}
+	require.Same(t, rejected, registry.GetOrRegister("set.b", rejected))
+	require.False(t, registry.IsValidMetric("set.b"))
+}
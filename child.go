@@ -0,0 +1,241 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import "time"
+
+func newChildRegistry(root *registryImpl, prefix string, tags map[string]string) *childRegistry {
+	return &childRegistry{
+		root:   root,
+		prefix: prefix,
+		tags:   tags,
+	}
+}
+
+func mergeTags(parent map[string]string, tags map[string]string) map[string]string {
+	result := make(map[string]string, len(parent)+len(tags))
+	for k, v := range parent {
+		result[k] = v
+	}
+	for k, v := range tags {
+		result[k] = v
+	}
+	return result
+}
+
+// childRegistry is a view on a registryImpl which stores its metrics under a name prefix
+type childRegistry struct {
+	root   *registryImpl
+	prefix string
+	tags   map[string]string
+}
+
+func (self *childRegistry) name(name string) string {
+	return self.prefix + name
+}
+
+func (self *childRegistry) SourceId() string {
+	return self.root.SourceId()
+}
+
+func (self *childRegistry) Tags() map[string]string {
+	return self.tags
+}
+
+func (self *childRegistry) Child(prefix string, tags map[string]string) Registry {
+	return newChildRegistry(self.root, self.name(prefix)+".", mergeTags(self.tags, tags))
+}
+
+func (self *childRegistry) Gauge(name string, options ...MetricOption) Gauge {
+	return self.root.Gauge(self.name(name), options...)
+}
+
+func (self *childRegistry) FuncGauge(name string, f func() int64, options ...MetricOption) Gauge {
+	return self.root.FuncGauge(self.name(name), f, options...)
+}
+
+func (self *childRegistry) GaugeFloat64(name string, options ...MetricOption) GaugeFloat64 {
+	return self.root.GaugeFloat64(self.name(name), options...)
+}
+
+func (self *childRegistry) FuncGaugeFloat64(name string, f func() float64, options ...MetricOption) GaugeFloat64 {
+	return self.root.FuncGaugeFloat64(self.name(name), f, options...)
+}
+
+func (self *childRegistry) RatioGauge(name string, numerator string, denominator string, options ...MetricOption) GaugeFloat64 {
+	return self.root.RatioGauge(self.name(name), self.name(numerator), self.name(denominator), options...)
+}
+
+func (self *childRegistry) ExpressionGauge(name string, expression string, options ...MetricOption) GaugeFloat64 {
+	gauge, err := self.TryExpressionGauge(name, expression, options...)
+	return handleCreateError(self.root, self.name(name), gauge, err, newNoopGaugeFloat64)
+}
+
+func (self *childRegistry) Meter(name string, options ...MetricOption) Meter {
+	return self.root.Meter(self.name(name), options...)
+}
+
+func (self *childRegistry) Histogram(name string, options ...MetricOption) Histogram {
+	return self.root.Histogram(self.name(name), options...)
+}
+
+func (self *childRegistry) Timer(name string, options ...MetricOption) Timer {
+	return self.root.Timer(self.name(name), options...)
+}
+
+func (self *childRegistry) WindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) WindowCounter {
+	return self.root.WindowCounter(self.name(name), window, buckets, options...)
+}
+
+func (self *childRegistry) Counter(name string, options ...MetricOption) Counter {
+	return self.root.Counter(self.name(name), options...)
+}
+
+func (self *childRegistry) Operation(name string, options ...MetricOption) Operation {
+	return self.root.Operation(self.name(name), options...)
+}
+
+func (self *childRegistry) Rollup(name string, pattern string, metricType MetricType, aggregation Aggregation, options ...MetricOption) Rollup {
+	return self.root.Rollup(self.name(name), self.name(pattern), metricType, aggregation, options...)
+}
+
+func (self *childRegistry) TryGauge(name string, options ...MetricOption) (Gauge, error) {
+	return self.root.TryGauge(self.name(name), options...)
+}
+
+func (self *childRegistry) TryFuncGauge(name string, f func() int64, options ...MetricOption) (Gauge, error) {
+	return self.root.TryFuncGauge(self.name(name), f, options...)
+}
+
+func (self *childRegistry) TryGaugeFloat64(name string, options ...MetricOption) (GaugeFloat64, error) {
+	return self.root.TryGaugeFloat64(self.name(name), options...)
+}
+
+func (self *childRegistry) TryFuncGaugeFloat64(name string, f func() float64, options ...MetricOption) (GaugeFloat64, error) {
+	return self.root.TryFuncGaugeFloat64(self.name(name), f, options...)
+}
+
+func (self *childRegistry) TryRatioGauge(name string, numerator string, denominator string, options ...MetricOption) (GaugeFloat64, error) {
+	return self.root.TryRatioGauge(self.name(name), self.name(numerator), self.name(denominator), options...)
+}
+
+// TryExpressionGauge resolves the references of the expression relative to the child's prefix
+func (self *childRegistry) TryExpressionGauge(name string, expression string, options ...MetricOption) (GaugeFloat64, error) {
+	return self.root.tryExpressionGauge(self.name(name), expression, self.prefix, options)
+}
+
+func (self *childRegistry) TryMeter(name string, options ...MetricOption) (Meter, error) {
+	return self.root.TryMeter(self.name(name), options...)
+}
+
+func (self *childRegistry) TryHistogram(name string, options ...MetricOption) (Histogram, error) {
+	return self.root.TryHistogram(self.name(name), options...)
+}
+
+func (self *childRegistry) TryTimer(name string, options ...MetricOption) (Timer, error) {
+	return self.root.TryTimer(self.name(name), options...)
+}
+
+func (self *childRegistry) TryWindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) (WindowCounter, error) {
+	return self.root.TryWindowCounter(self.name(name), window, buckets, options...)
+}
+
+func (self *childRegistry) TryCounter(name string, options ...MetricOption) (Counter, error) {
+	return self.root.TryCounter(self.name(name), options...)
+}
+
+func (self *childRegistry) TryOperation(name string, options ...MetricOption) (Operation, error) {
+	return self.root.TryOperation(self.name(name), options...)
+}
+
+func (self *childRegistry) TryRollup(name string, pattern string, metricType MetricType, aggregation Aggregation, options ...MetricOption) (Rollup, error) {
+	return self.root.TryRollup(self.name(name), self.name(pattern), metricType, aggregation, options...)
+}
+
+func (self *childRegistry) Register(name string, metric interface{}) error {
+	return self.root.Register(self.name(name), metric)
+}
+
+func (self *childRegistry) Unregister(name string) {
+	self.root.Unregister(self.name(name))
+}
+
+func (self *childRegistry) EachMetric(visitor func(name string, metric Metric)) {
+	self.root.eachMetricWithPrefix(self.prefix, visitor)
+}
+
+func (self *childRegistry) GetGauge(name string) Gauge {
+	return self.root.GetGauge(self.name(name))
+}
+
+func (self *childRegistry) GetGaugeFloat64(name string) GaugeFloat64 {
+	return self.root.GetGaugeFloat64(self.name(name))
+}
+
+func (self *childRegistry) GetMeter(name string) Meter {
+	return self.root.GetMeter(self.name(name))
+}
+
+func (self *childRegistry) GetHistogram(name string) Histogram {
+	return self.root.GetHistogram(self.name(name))
+}
+
+func (self *childRegistry) GetTimer(name string) Timer {
+	return self.root.GetTimer(self.name(name))
+}
+
+func (self *childRegistry) IsValidMetric(name string) bool {
+	return self.root.IsValidMetric(self.name(name))
+}
+
+func (self *childRegistry) AcceptVisitor(visitor Visitor) {
+	self.root.acceptVisitor(visitor, self.prefix)
+}
+
+func (self *childRegistry) SetMetadata(name string, metadata Metadata) {
+	self.root.SetMetadata(self.name(name), metadata)
+}
+
+func (self *childRegistry) Metadata(name string) (Metadata, bool) {
+	return self.root.Metadata(self.name(name))
+}
+
+func (self *childRegistry) Describe(visitor DescribeVisitor) {
+	self.root.describe(visitor, self.prefix)
+}
+
+func (self *childRegistry) DisposeAll() {
+	self.root.disposeWithPrefix(self.prefix)
+}
+
+func (self *childRegistry) AddListener(listener RegistryListener) {
+	self.root.addListener(self.prefix, listener)
+}
+
+func (self *childRegistry) RemoveListener(listener RegistryListener) {
+	self.root.removeListener(self.prefix, listener)
+}
+
+// RefCountCheckpoint takes a checkpoint of the root registry, so it applies to all of its metrics, not just
+// the child's. RefCountReport on the child only lists the child's metrics
+func (self *childRegistry) RefCountCheckpoint() {
+	self.root.RefCountCheckpoint()
+}
+
+func (self *childRegistry) RefCountReport() RefCountReport {
+	return self.root.refCountReport(self.prefix)
+}
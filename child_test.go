@@ -0,0 +1,85 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChildRegistry(t *testing.T) {
+	registry := NewRegistry("router", map[string]string{"host": "a"})
+	registry.Meter("link.rx.bytes")
+
+	link := registry.Child("link.l1", map[string]string{"link": "l1"})
+	require.Equal(t, "router", link.SourceId())
+	require.Equal(t, map[string]string{"host": "a", "link": "l1"}, link.Tags())
+
+	link.Meter("tx.bytes").Mark(10)
+	link.Histogram("latency")
+	circuit := link.Child("circuit.c1", nil)
+	circuit.Timer("duration")
+
+	require.Equal(t, int64(10), registry.GetMeter("link.l1.tx.bytes").Count())
+	require.NotNil(t, link.GetTimer("circuit.c1.duration"))
+	require.True(t, registry.IsValidMetric("link.l1.circuit.c1.duration"))
+
+	var names []string
+	link.EachMetric(func(name string, metric Metric) {
+		names = append(names, name)
+	})
+	require.ElementsMatch(t, []string{"link.l1.tx.bytes", "link.l1.latency", "link.l1.circuit.c1.duration",
+		"link.l1.circuit.c1.duration.success", "link.l1.circuit.c1.duration.failure"}, names)
+
+	// the timer's outcome sub-timers are visited along with it
+	visitor := newCollectingVisitor()
+	circuit.AcceptVisitor(visitor)
+	require.Len(t, visitor.timers, 3)
+	require.Empty(t, visitor.meters)
+
+	// a sibling whose prefix shares characters must not be affected
+	other := registry.Child("link.l10", nil)
+	other.Gauge("up")
+
+	// an extra reference must not keep the metric alive
+	link.Meter("tx.bytes")
+	link.DisposeAll()
+
+	require.False(t, registry.IsValidMetric("link.l1.tx.bytes"))
+	require.False(t, registry.IsValidMetric("link.l1.latency"))
+	require.False(t, registry.IsValidMetric("link.l1.circuit.c1.duration"))
+	require.True(t, registry.IsValidMetric("link.rx.bytes"))
+	require.True(t, registry.IsValidMetric("link.l10.up"))
+}
+
+func TestChildRegistryDisposeAllIsScoped(t *testing.T) {
+	registry := NewRegistry("router", nil).(*registryImpl)
+	link := registry.Child("link.l1", nil)
+	for _, name := range []string{"tx", "rx", "circuit.c1.latency"} {
+		link.Histogram(name)
+	}
+	registry.Gauge("link.l1").Update(1)
+
+	link.DisposeAll()
+	require.True(t, registry.IsValidMetric("link.l1"))
+	require.Equal(t, []string{"link.l1"}, namesWithPrefix(&registry.names, ""))
+
+	// the child can be used again after DisposeAll
+	link.Histogram("tx")
+	require.True(t, registry.IsValidMetric("link.l1.tx"))
+}
@@ -0,0 +1,108 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+// Counter represents a metric which counts events, without tracking rates. Counters are sharded, so they
+// can be updated from many goroutines at once without contention, at the cost of more expensive reads.
+// They are visited as gauges holding their count.
+type Counter interface {
+	Metric
+	Inc(int64)
+	Dec(int64)
+	Count() int64
+	Clear()
+}
+
+type counterImpl struct {
+	*stripedCounter
+	name     string
+	unit     Unit
+	registry *registryImpl
+	refCount
+	idleTracker
+}
+
+func (self *counterImpl) Inc(n int64) {
+	self.markUpdated()
+	self.Add(n)
+}
+
+func (self *counterImpl) Dec(n int64) {
+	self.markUpdated()
+	self.Add(-n)
+}
+
+func (self *counterImpl) Count() int64 {
+	return self.Load()
+}
+
+func (self *counterImpl) Name() string {
+	return self.name
+}
+
+func (self *counterImpl) Unit() Unit {
+	return self.unit
+}
+
+func (self *counterImpl) Dispose() {
+	self.registry.disposeRefCounted(self)
+}
+
+func (self *counterImpl) stop() {
+	// no resources to cleanup
+}
+
+func (self *counterImpl) AcceptVisitor(name string, visitor Visitor) {
+	visitor.VisitGauge(name, (*counterGauge)(self))
+}
+
+// counterGauge presents a counter as a read-only gauge, so it can be visited without allocating a snapshot
+type counterGauge counterImpl
+
+func (self *counterGauge) Value() int64 { return (*counterImpl)(self).Count() }
+func (self *counterGauge) Update(int64) {}
+func (self *counterGauge) Unit() Unit   { return self.unit }
+func (self *counterGauge) Dispose()     {}
+
+func (registry *registryImpl) Counter(name string, options ...MetricOption) Counter {
+	counter, err := registry.TryCounter(name, options...)
+	return handleCreateError(registry, name, counter, err, newNoopCounter)
+}
+
+func (registry *registryImpl) TryCounter(name string, options ...MetricOption) (Counter, error) {
+	return getOrCreateRefCounted[Counter](registry, name, MetricTypeCounter, func(name string) refCounted {
+		return &counterImpl{
+			stripedCounter: newStripedCounter(),
+			name:           name,
+			unit:           newMetricConfig(options).unit,
+			registry:       registry,
+		}
+	})
+}
+
+type noopCounter struct{}
+
+func newNoopCounter() Counter {
+	return noopCounter{}
+}
+
+func (noopCounter) Inc(int64)    {}
+func (noopCounter) Dec(int64)    {}
+func (noopCounter) Count() int64 { return 0 }
+func (noopCounter) Clear()       {}
+func (noopCounter) Unit() Unit   { return UnitNone }
+func (noopCounter) Dispose()     {}
@@ -0,0 +1,100 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMetricTypeConflict matches, using errors.Is, any MetricTypeConflictError
+var ErrMetricTypeConflict = errors.New("metric type conflict")
+
+// MetricTypeConflictError is returned when a metric is requested under a name which is already used by a
+// metric of a different type
+type MetricTypeConflictError struct {
+	Name      string
+	Existing  MetricType
+	Requested MetricType
+}
+
+func newMetricTypeConflictError(name string, existing Metric, requested MetricType) *MetricTypeConflictError {
+	existingType := MetricTypeOf(existing)
+	if existingType == "" {
+		existingType = MetricType(fmt.Sprintf("%T", unwrapMetric(existing)))
+	}
+	return &MetricTypeConflictError{
+		Name:      name,
+		Existing:  existingType,
+		Requested: requested,
+	}
+}
+
+func (self *MetricTypeConflictError) Error() string {
+	return fmt.Sprintf("metric '%v' already exists and is not a %v. It is a %v", self.Name, self.Requested, self.Existing)
+}
+
+func (self *MetricTypeConflictError) Is(target error) bool {
+	return target == ErrMetricTypeConflict
+}
+
+// ErrCardinalityLimitExceeded matches, using errors.Is, any CardinalityLimitError
+var ErrCardinalityLimitExceeded = errors.New("metric cardinality limit exceeded")
+
+// CardinalityLimitError is returned when a metric can't be created because a CardinalityLimit without an
+// overflow name has been reached
+type CardinalityLimitError struct {
+	Name   string
+	Prefix string
+	Max    int
+}
+
+func newCardinalityLimitError(name string, limit *cardinalityLimit) *CardinalityLimitError {
+	return &CardinalityLimitError{
+		Name:   name,
+		Prefix: limit.Prefix,
+		Max:    limit.Max,
+	}
+}
+
+func (self *CardinalityLimitError) Error() string {
+	return fmt.Sprintf("unable to create metric '%v', the limit of %v metrics with prefix '%v' has been reached", self.Name, self.Max, self.Prefix)
+}
+
+func (self *CardinalityLimitError) Is(target error) bool {
+	return target == ErrCardinalityLimitExceeded
+}
+
+// ErrNotMergeable is returned by MergeHistograms and MergeTimers when the inputs have different units, or
+// when the sample of an input isn't available
+var ErrNotMergeable = errors.New("metrics can't be merged")
+
+// ErrInvalidRollup is returned when a Rollup is requested with an invalid pattern, or with an aggregation
+// or metric type which isn't supported
+var ErrInvalidRollup = errors.New("invalid rollup")
+
+// ErrInvalidExpression is returned when an ExpressionGauge is requested with an expression which can't be parsed
+var ErrInvalidExpression = errors.New("invalid expression")
+
+// ErrInvalidWindow is returned when a WindowCounter is requested with a window which isn't positive, or
+// which can't be split into the requested number of buckets, and when a metric is requested with a rate
+// window which isn't positive
+var ErrInvalidWindow = errors.New("invalid window")
+
+// ErrDuplicateSource is returned when a registry is added to a MultiRegistry which already holds a registry
+// with the same source id
+var ErrDuplicateSource = errors.New("duplicate source")
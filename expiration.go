@@ -0,0 +1,217 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// MetricNameIdleEvictions is the name of the gauge which counts metrics evicted by idle expiration
+const MetricNameIdleEvictions = "metrics.idle_evictions"
+
+// minIdleCheckInterval bounds how often expiration checks run for very short ttls
+const minIdleCheckInterval = time.Millisecond
+
+// WithIdleExpiration configures the registry to evict metrics which haven't been updated for the given ttl,
+// regardless of any outstanding references to them. Func gauges are never evicted, as their values are
+// computed. An evicted metric which is still held, and is updated again, puts itself back into the
+// registry, so it's reported again. Evicted metrics aren't stopped, as they may still be in use; their
+// resources are released once they're no longer referenced. The given callback, if not nil, is called with
+// each evicted metric. Evictions are counted by the MetricNameIdleEvictions gauge. Expiration checks run
+// every ttl/2, but at most every millisecond, until closeNotify is closed. A ttl which isn't positive
+// disables expiration.
+func WithIdleExpiration(ttl time.Duration, closeNotify <-chan struct{}, onEvict func(name string, metric Metric)) RegistryOption {
+	return func(registry *registryImpl) {
+		if ttl <= 0 {
+			registry.idleExpiration = nil
+			return
+		}
+		registry.idleExpiration = &idleExpiration{
+			ttl:         ttl,
+			closeNotify: closeNotify,
+			onEvict:     onEvict,
+		}
+	}
+}
+
+type idleExpiration struct {
+	ttl         time.Duration
+	closeNotify <-chan struct{}
+	onEvict     func(name string, metric Metric)
+	evictions   atomic.Int64
+}
+
+func (registry *registryImpl) startIdleExpiration() {
+	registry.FuncGauge(MetricNameIdleEvictions, registry.idleExpiration.evictions.Load)
+
+	go func() {
+		ticker := time.NewTicker(max(registry.idleExpiration.ttl/2, minIdleCheckInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				registry.evictIdle(now)
+			case <-registry.idleExpiration.closeNotify:
+				return
+			}
+		}
+	}()
+}
+
+func (registry *registryImpl) evictIdle(now time.Time) {
+	registry.EachMetric(func(name string, metric Metric) {
+		tracked, ok := metric.(idleTracked)
+		if !ok {
+			return
+		}
+
+		// the eviction is recorded before checking whether the metric is idle, so an update racing with the
+		// check either keeps the metric, or finds the eviction and puts the metric back
+		evicted := &evictedMetric{registry: registry, name: name, metric: metric}
+		// evicted metrics aren't marked as removed, so they keep count of their holders while out of the registry
+		removed := registry.removeEntryIf(name, func(existing Metric) bool {
+			if existing != metric {
+				return false
+			}
+			tracked.setEvicted(evicted)
+			if tracked.idleFor(now) < registry.idleExpiration.ttl {
+				tracked.clearEvicted(evicted)
+				return false
+			}
+			return true
+		})
+
+		if removed {
+			releaseWhenUnreachable(metric)
+			registry.idleExpiration.evictions.Add(1)
+			if registry.idleExpiration.onEvict != nil {
+				registry.idleExpiration.onEvict(name, metric)
+			}
+		}
+	})
+}
+
+// reregister puts an evicted metric, which has been updated since, back into the registry, along with the
+// references still held to it. If the name has been reused in the meantime, the evicted metric stays out of
+// the registry
+func (registry *registryImpl) reregister(name string, metric Metric) {
+	inserted := false
+	var refCount int32
+	registry.metricMap.Upsert(name, metric, func(exist bool, valueInMap Metric, newValue Metric) Metric {
+		if exist {
+			return valueInMap
+		}
+		if rc, ok := newValue.(refCounted); ok {
+			refCount = rc.refs()
+		}
+		inserted = true
+		return newValue
+	})
+	if inserted {
+		registry.metricAdded(name, metric, refCount)
+	}
+}
+
+// releaseWhenUnreachable stops the go-metrics meters backing an evicted metric once the metric is garbage
+// collected, so they don't stay registered with the go-metrics arbiter
+func releaseWhenUnreachable(metric Metric) {
+	switch m := metric.(type) {
+	case *meterImpl:
+		runtime.AddCleanup(m, stopAll, []metrics.Stoppable{m.Meter})
+	case *timerImpl:
+		runtime.AddCleanup(m, stopAll, []metrics.Stoppable{m.Timer})
+	case *operationImpl:
+		stoppables := []metrics.Stoppable{m.timer.Timer}
+		for _, meter := range m.outcomes.Load().meters {
+			stoppables = append(stoppables, meter.Meter)
+		}
+		runtime.AddCleanup(m, stopAll, stoppables)
+	}
+}
+
+func stopAll(stoppables []metrics.Stoppable) {
+	for _, stoppable := range stoppables {
+		stoppable.Stop()
+	}
+}
+
+// markUsed records activity on a metric being handed out by the registry, so it isn't evicted right away
+func markUsed(metric Metric) {
+	if tracked, ok := metric.(idleTracked); ok {
+		tracked.markUpdated()
+	}
+}
+
+type idleTracked interface {
+	markUpdated()
+	idleFor(now time.Time) time.Duration
+	setEvicted(evicted *evictedMetric)
+	clearEvicted(evicted *evictedMetric)
+}
+
+// evictedMetric records where an evicted metric was registered, so it can put itself back when updated
+type evictedMetric struct {
+	registry *registryImpl
+	name     string
+	metric   Metric
+}
+
+// idleTracker is embedded in metrics to track when they were last updated. Updates only set a flag, which
+// is folded into the last update time when the registry checks for idle metrics, so the update path
+// stays cheap. The first update after the metric has been evicted puts it back into its registry.
+type idleTracker struct {
+	exempt     bool
+	updated    atomic.Bool
+	lastUpdate atomic.Int64
+	evicted    atomic.Pointer[evictedMetric]
+}
+
+func (self *idleTracker) markUpdated() {
+	if !self.updated.Load() {
+		self.updated.Store(true)
+		if evicted := self.evicted.Load(); evicted != nil && self.evicted.CompareAndSwap(evicted, nil) {
+			evicted.registry.reregister(evicted.name, evicted.metric)
+		}
+	}
+}
+
+func (self *idleTracker) setEvicted(evicted *evictedMetric) {
+	self.evicted.Store(evicted)
+}
+
+func (self *idleTracker) clearEvicted(evicted *evictedMetric) {
+	self.evicted.CompareAndSwap(evicted, nil)
+}
+
+// idleFor returns how long the metric has gone without updates, as of now. Metrics which haven't been
+// checked before are treated as just updated.
+func (self *idleTracker) idleFor(now time.Time) time.Duration {
+	if self.exempt {
+		return 0
+	}
+	nowNanos := now.UnixNano()
+	if self.updated.Swap(false) || self.lastUpdate.Load() == 0 {
+		self.lastUpdate.Store(nowNanos)
+		return 0
+	}
+	return time.Duration(nowNanos - self.lastUpdate.Load())
+}
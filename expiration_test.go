@@ -0,0 +1,141 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleExpiration(t *testing.T) {
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	var evicted []string
+	registry := NewRegistry("test", nil, WithIdleExpiration(time.Hour, closeNotify, func(name string, metric Metric) {
+		evicted = append(evicted, name)
+	})).(*registryImpl)
+
+	meter := registry.Meter("meter")
+	registry.Meter("meter")
+	histogram := registry.Histogram("histogram")
+	registry.Timer("timer")
+	registry.FuncGauge("func", func() int64 { return 1 })
+
+	now := time.Now()
+	registry.evictIdle(now)
+	require.Empty(t, evicted)
+
+	meter.Mark(1)
+	histogram.Update(1)
+	registry.evictIdle(now.Add(30 * time.Minute))
+	require.Empty(t, evicted)
+
+	registry.evictIdle(now.Add(61 * time.Minute))
+	require.ElementsMatch(t, []string{"timer", "timer.success", "timer.failure"}, evicted)
+	require.False(t, registry.IsValidMetric("timer"))
+
+	registry.evictIdle(now.Add(91 * time.Minute))
+	require.ElementsMatch(t, []string{"timer", "timer.success", "timer.failure", "meter", "histogram"}, evicted)
+	require.True(t, registry.IsValidMetric("func"))
+	require.Equal(t, int64(5), registry.GetGauge(MetricNameIdleEvictions).Value())
+
+	// outstanding handles can still be disposed safely
+	meter.Dispose()
+	histogram.Dispose()
+}
+
+func TestIdleExpirationOfHeldMetric(t *testing.T) {
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	registry := NewRegistry("test", nil, WithIdleExpiration(time.Hour, closeNotify, nil)).(*registryImpl)
+	meter := registry.Meter("held")
+	gauge := registry.Gauge("gauge")
+
+	now := time.Now()
+	registry.evictIdle(now)
+	registry.evictIdle(now.Add(61 * time.Minute))
+	require.False(t, registry.IsValidMetric("held"))
+	require.False(t, registry.IsValidMetric("gauge"))
+
+	// updating an evicted metric puts it back, so it's reported again
+	meter.Mark(5)
+	gauge.Update(3)
+	require.Same(t, meter, registry.GetMeter("held"))
+	require.Equal(t, int64(5), registry.GetMeter("held").Count())
+	require.Equal(t, int64(3), registry.GetGauge("gauge").Value())
+
+	// its holder count is kept while it's out of the registry, so disposing its only holder removes it
+	meter.Dispose()
+	require.False(t, registry.IsValidMetric("held"))
+
+	// an evicted metric whose name has been reused stays out of the registry
+	registry.evictIdle(now.Add(122 * time.Minute))
+	registry.evictIdle(now.Add(183 * time.Minute))
+	require.False(t, registry.IsValidMetric("gauge"))
+	replacement := registry.Gauge("gauge")
+	gauge.Update(4)
+	require.Same(t, replacement, registry.GetGauge("gauge"))
+}
+
+func TestIdleExpirationKeepsHolders(t *testing.T) {
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	registry := NewRegistry("test", nil, WithIdleExpiration(time.Hour, closeNotify, nil)).(*registryImpl)
+	a := registry.Meter("m")
+	b := registry.Meter("m")
+
+	now := time.Now()
+	registry.evictIdle(now)
+	registry.evictIdle(now.Add(61 * time.Minute))
+	require.False(t, registry.IsValidMetric("m"))
+
+	// a holder releasing the metric while it's evicted leaves the other holder's reference
+	a.Mark(1)
+	require.True(t, registry.IsValidMetric("m"))
+	a.Dispose()
+	require.True(t, registry.IsValidMetric("m"))
+	b.Mark(1)
+	require.Same(t, b, registry.GetMeter("m"))
+
+	// releases while the metric is out of the registry are counted too
+	registry.evictIdle(now.Add(122 * time.Minute))
+	registry.evictIdle(now.Add(183 * time.Minute))
+	require.False(t, registry.IsValidMetric("m"))
+	b.Dispose()
+	b.Mark(1)
+	require.Equal(t, int32(0), b.(*meterImpl).refs())
+}
+
+func TestIdleExpirationTtl(t *testing.T) {
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	registry := NewRegistry("test", nil, WithIdleExpiration(0, closeNotify, nil)).(*registryImpl)
+	require.Nil(t, registry.idleExpiration)
+
+	// very short ttls are checked at most every millisecond, rather than making the ticker panic
+	registry = NewRegistry("test", nil, WithIdleExpiration(time.Nanosecond, closeNotify, nil)).(*registryImpl)
+	registry.Meter("meter").Mark(1)
+	require.Eventually(t, func() bool {
+		return !registry.IsValidMetric("meter")
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,503 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type expression interface {
+	evaluate(evaluation *evaluation) float64
+}
+
+// evaluation holds the state of evaluating a computed gauge. Each computed gauge referenced along the way
+// is evaluated once, and a gauge which references itself, directly or through others, evaluates to NaN
+type evaluation struct {
+	registry *registryImpl
+	active   []*computedGauge
+	values   map[*computedGauge]float64
+}
+
+func (self *evaluation) evaluateGauge(gauge *computedGauge) float64 {
+	if value, found := self.values[gauge]; found {
+		return value
+	}
+	if slices.Contains(self.active, gauge) {
+		return math.NaN()
+	}
+
+	self.active = append(self.active, gauge)
+	value := gauge.expression.evaluate(self)
+	self.active = self.active[:len(self.active)-1]
+
+	if self.values == nil {
+		self.values = map[*computedGauge]float64{}
+	}
+	self.values[gauge] = value
+	return value
+}
+
+type constantExpression float64
+
+func (self constantExpression) evaluate(*evaluation) float64 {
+	return float64(self)
+}
+
+type referenceExpression string
+
+func (self referenceExpression) evaluate(evaluation *evaluation) float64 {
+	return evaluation.resolve(string(self))
+}
+
+type negateExpression struct {
+	operand expression
+}
+
+func (self negateExpression) evaluate(evaluation *evaluation) float64 {
+	return -self.operand.evaluate(evaluation)
+}
+
+type binaryExpression struct {
+	operator byte
+	left     expression
+	right    expression
+}
+
+func (self binaryExpression) evaluate(evaluation *evaluation) float64 {
+	left := self.left.evaluate(evaluation)
+	right := self.right.evaluate(evaluation)
+	switch self.operator {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	default:
+		if right == 0 {
+			return math.NaN()
+		}
+		return left / right
+	}
+}
+
+// resolve returns the value of a reference, see ExpressionGauge, or NaN if it can't be resolved
+func (self *evaluation) resolve(reference string) float64 {
+	if metric, found := self.registry.metricMap.Get(reference); found {
+		if value, ok := self.scalarValue(reference, metric); ok {
+			return value
+		}
+	}
+
+	// <metric>.<field>
+	idx := strings.LastIndexByte(reference, '.')
+	if idx < 0 {
+		return math.NaN()
+	}
+	name, field := reference[:idx], reference[idx+1:]
+	if metric, found := self.registry.metricMap.Get(name); found {
+		return fieldValue(self.derivedMetric(name, metric), field)
+	}
+
+	// <operation>.<outcome>.<field>
+	if idx = strings.LastIndexByte(name, '.'); idx >= 0 {
+		if metric, found := self.registry.metricMap.Get(name[:idx]); found {
+			if operation, ok := metric.(Operation); ok {
+				if meter := operation.Outcome(name[idx+1:]); meter != nil {
+					return fieldValue(meter, field)
+				}
+			}
+		}
+	}
+	return math.NaN()
+}
+
+// scalarValue returns the value of a metric which is referenced by name alone
+func (self *evaluation) scalarValue(name string, metric Metric) (float64, bool) {
+	switch m := self.derivedMetric(name, metric).(type) {
+	case *computedGauge:
+		return self.evaluateGauge(m), true
+	case Gauge:
+		return float64(m.Value()), true
+	case GaugeFloat64:
+		return m.Value(), true
+	case Counter:
+		return float64(m.Count()), true
+	}
+	return 0, false
+}
+
+// derivedMetric returns the metric a rollup is visited as, or the given metric if it isn't a rollup
+func (self *evaluation) derivedMetric(name string, metric Metric) Metric {
+	rollup, ok := metric.(*rollupImpl)
+	if !ok {
+		return metric
+	}
+	visitor := &capturingVisitor{}
+	rollup.visit(name, visitor, self)
+	return visitor.metric
+}
+
+// capturingVisitor keeps the last metric it's given
+type capturingVisitor struct {
+	metric Metric
+}
+
+func (self *capturingVisitor) VisitGauge(_ string, gauge Gauge)               { self.metric = gauge }
+func (self *capturingVisitor) VisitGaugeFloat64(_ string, gauge GaugeFloat64) { self.metric = gauge }
+func (self *capturingVisitor) VisitMeter(_ string, meter Meter)               { self.metric = meter }
+func (self *capturingVisitor) VisitHistogram(_ string, histogram Histogram)   { self.metric = histogram }
+func (self *capturingVisitor) VisitTimer(_ string, timer Timer)               { self.metric = timer }
+
+// fieldValue returns the value of the given field of a metric, or NaN if the metric doesn't have it
+func fieldValue(metric Metric, field string) float64 {
+	switch m := metric.(type) {
+	case Operation:
+		return fieldValue(m.Timer(), field)
+	case Timer:
+		snapshot := m.CreateSnapshot()
+		if value, ok := rateFieldValue(snapshot, field); ok {
+			return value
+		}
+		return histogramFieldValue(timerHistogram{Timer: snapshot}, field)
+	case Histogram:
+		return histogramFieldValue(m.CreateSnapshot(), field)
+	case Meter:
+		if value, ok := rateFieldValue(m, field); ok {
+			return value
+		}
+	case WindowCounter:
+		switch field {
+		case MetricNameCount:
+			return float64(m.Total())
+		case "rate", RateWindowName(m.Window()):
+			return m.Rate()
+		}
+	case Counter:
+		if field == MetricNameCount {
+			return float64(m.Count())
+		}
+	}
+	return math.NaN()
+}
+
+type rateFieldSource interface {
+	standardRateSource
+	Count() int64
+	RateMean() float64
+}
+
+// rateFieldValue returns the count or a rate of a meter or timer
+func rateFieldValue(source rateFieldSource, field string) (float64, bool) {
+	switch field {
+	case MetricNameCount:
+		return float64(source.Count()), true
+	case MetricNameRateM1:
+		return source.Rate1(), true
+	case MetricNameRateM5:
+		return source.Rate5(), true
+	case MetricNameRateM15:
+		return source.Rate15(), true
+	case MetricNameRateMean:
+		return source.RateMean(), true
+	}
+	for _, window := range rateWindowsOf(source) {
+		if field == RateWindowName(window) {
+			return rateOf(source, window), true
+		}
+	}
+	return 0, false
+}
+
+func histogramFieldValue(histogram Histogram, field string) float64 {
+	switch field {
+	case MetricNameCount:
+		return float64(histogram.Count())
+	case MetricNameMean:
+		return histogram.Mean()
+	case MetricNameMin:
+		return float64(histogram.Min())
+	case MetricNameMax:
+		return float64(histogram.Max())
+	case MetricNameStdDev:
+		return histogram.StdDev()
+	case MetricNameVariance:
+		return histogram.Variance()
+	case MetricNameSum:
+		return float64(histogram.Sum())
+	}
+	if percentile, ok := parsePercentileField(field); ok {
+		return histogram.Percentile(percentile)
+	}
+	return math.NaN()
+}
+
+// parsePercentileField parses percentile fields as named by PercentileNameShort, for example p05, p50 and
+// p999. The first two digits are the whole part of the percentage, and any others its fraction
+func parsePercentileField(field string) (float64, bool) {
+	digits, found := strings.CutPrefix(field, "p")
+	if !found || len(digits) < 2 || strings.Trim(digits, "0123456789") != "" {
+		return 0, false
+	}
+	if digits == "100" {
+		return 1, true
+	}
+	if len(digits) > 2 {
+		digits = digits[:2] + "." + digits[2:]
+	}
+	percent, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0, false
+	}
+	return percent / 100, true
+}
+
+// parseExpression parses an expression, prepending the given prefix to each of its references
+func parseExpression(source string, prefix string) (expression, error) {
+	parser := &expressionParser{
+		source: source,
+		prefix: prefix,
+	}
+	result, err := parser.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if parser.skipSpace(); parser.pos < len(source) {
+		return nil, parser.errorf("unexpected '%c'", source[parser.pos])
+	}
+	return result, nil
+}
+
+type expressionParser struct {
+	source string
+	prefix string
+	pos    int
+}
+
+func (self *expressionParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %v at offset %v of '%v'", ErrInvalidExpression, fmt.Sprintf(format, args...), self.pos, self.source)
+}
+
+func (self *expressionParser) skipSpace() {
+	for self.pos < len(self.source) && (self.source[self.pos] == ' ' || self.source[self.pos] == '\t') {
+		self.pos++
+	}
+}
+
+// next skips whitespace and returns the next character, or 0 at the end of the expression
+func (self *expressionParser) next() byte {
+	self.skipSpace()
+	if self.pos < len(self.source) {
+		return self.source[self.pos]
+	}
+	return 0
+}
+
+func (self *expressionParser) parseSum() (expression, error) {
+	left, err := self.parseProduct()
+	for err == nil {
+		operator := self.next()
+		if operator != '+' && operator != '-' {
+			return left, nil
+		}
+		self.pos++
+		var right expression
+		if right, err = self.parseProduct(); err == nil {
+			left = binaryExpression{operator: operator, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+func (self *expressionParser) parseProduct() (expression, error) {
+	left, err := self.parseUnary()
+	for err == nil {
+		operator := self.next()
+		if operator != '*' && operator != '/' {
+			return left, nil
+		}
+		self.pos++
+		var right expression
+		if right, err = self.parseUnary(); err == nil {
+			left = binaryExpression{operator: operator, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+func (self *expressionParser) parseUnary() (expression, error) {
+	if self.next() != '-' {
+		return self.parsePrimary()
+	}
+	self.pos++
+	operand, err := self.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return negateExpression{operand: operand}, nil
+}
+
+func (self *expressionParser) parsePrimary() (expression, error) {
+	c := self.next()
+	switch {
+	case c == 0:
+		return nil, self.errorf("unexpected end of expression")
+	case c == '(':
+		self.pos++
+		result, err := self.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if self.next() != ')' {
+			return nil, self.errorf("expected ')'")
+		}
+		self.pos++
+		return result, nil
+	case c == '{':
+		end := strings.IndexByte(self.source[self.pos:], '}')
+		if end < 0 {
+			return nil, self.errorf("expected '}'")
+		}
+		name := strings.TrimSpace(self.source[self.pos+1 : self.pos+end])
+		if name == "" {
+			return nil, self.errorf("empty metric name")
+		}
+		self.pos += end + 1
+		return referenceExpression(self.prefix + name), nil
+	case isDigit(c) || c == '.':
+		return self.parseNumber()
+	case isNameStart(c):
+		start := self.pos
+		for self.pos < len(self.source) && (isNameStart(self.source[self.pos]) || isDigit(self.source[self.pos]) || self.source[self.pos] == '.') {
+			self.pos++
+		}
+		return referenceExpression(self.prefix + self.source[start:self.pos]), nil
+	}
+	return nil, self.errorf("unexpected '%c'", c)
+}
+
+func (self *expressionParser) parseNumber() (expression, error) {
+	start := self.pos
+	for self.pos < len(self.source) && (isDigit(self.source[self.pos]) || self.source[self.pos] == '.') {
+		self.pos++
+	}
+	if self.pos < len(self.source) && (self.source[self.pos] == 'e' || self.source[self.pos] == 'E') {
+		self.pos++
+		if self.pos < len(self.source) && (self.source[self.pos] == '+' || self.source[self.pos] == '-') {
+			self.pos++
+		}
+		for self.pos < len(self.source) && isDigit(self.source[self.pos]) {
+			self.pos++
+		}
+	}
+	text := self.source[start:self.pos]
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		self.pos = start
+		return nil, self.errorf("invalid number '%v'", text)
+	}
+	return constantExpression(value), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// computedGauge is a GaugeFloat64 whose value is evaluated from other metrics, see ExpressionGauge
+type computedGauge struct {
+	expression expression
+	registry   *registryImpl
+	unit       Unit
+	idleTracker
+	dispose func()
+}
+
+func (self *computedGauge) Value() float64 {
+	evaluation := &evaluation{registry: self.registry}
+	return evaluation.evaluateGauge(self)
+}
+
+func (self *computedGauge) Update(float64) {}
+
+func (self *computedGauge) Unit() Unit {
+	return self.unit
+}
+
+func (self *computedGauge) Dispose() {
+	self.dispose()
+}
+
+func (self *computedGauge) AcceptVisitor(name string, visitor Visitor) {
+	value := self.Value()
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	visitor.VisitGaugeFloat64(name, &gaugeFloat64Snapshot{value: value, unit: self.unit})
+}
+
+func (registry *registryImpl) RatioGauge(name string, numerator string, denominator string, options ...MetricOption) GaugeFloat64 {
+	gauge, err := registry.TryRatioGauge(name, numerator, denominator, options...)
+	return handleCreateError(registry, name, gauge, err, newNoopGaugeFloat64)
+}
+
+func (registry *registryImpl) TryRatioGauge(name string, numerator string, denominator string, options ...MetricOption) (GaugeFloat64, error) {
+	return registry.tryComputedGauge(name, binaryExpression{
+		operator: '/',
+		left:     referenceExpression(numerator),
+		right:    referenceExpression(denominator),
+	}, options)
+}
+
+func (registry *registryImpl) ExpressionGauge(name string, expression string, options ...MetricOption) GaugeFloat64 {
+	gauge, err := registry.TryExpressionGauge(name, expression, options...)
+	return handleCreateError(registry, name, gauge, err, newNoopGaugeFloat64)
+}
+
+func (registry *registryImpl) TryExpressionGauge(name string, expression string, options ...MetricOption) (GaugeFloat64, error) {
+	return registry.tryExpressionGauge(name, expression, "", options)
+}
+
+func (registry *registryImpl) tryExpressionGauge(name string, source string, prefix string, options []MetricOption) (GaugeFloat64, error) {
+	parsed, err := parseExpression(source, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return registry.tryComputedGauge(name, parsed, options)
+}
+
+func (registry *registryImpl) tryComputedGauge(name string, expression expression, options []MetricOption) (GaugeFloat64, error) {
+	return getOrCreateMetric(registry, name, MetricTypeGaugeFloat64, func(name string) GaugeFloat64 {
+		gauge := &computedGauge{
+			expression:  expression,
+			registry:    registry,
+			unit:        newMetricConfig(options).unit,
+			idleTracker: idleTracker{exempt: true},
+		}
+		gauge.dispose = func() {
+			registry.remove(name, gauge)
+		}
+		return gauge
+	})
+}
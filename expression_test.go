@@ -0,0 +1,257 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpression(t *testing.T) {
+	registry := NewRegistry("test", nil).(*registryImpl)
+
+	tests := map[string]float64{
+		"1 + 2 * 3":         7,
+		"(1 + 2) * 3":       9,
+		"10 - 4 - 3":        3,
+		"12 / 3 / 2":        2,
+		"-2 * -3":           6,
+		"1.5e2 + .5":        150.5,
+		"2 * (3 - (4 - 1))": 0,
+	}
+	for source, expected := range tests {
+		parsed, err := parseExpression(source, "")
+		require.NoError(t, err, source)
+		require.Equal(t, expected, parsed.evaluate(&evaluation{registry: registry}), source)
+	}
+
+	for _, source := range []string{"", "1 +", "(1 + 2", "1 2", "{}", "{a", "1 $ 2", "1..2"} {
+		_, err := parseExpression(source, "")
+		require.ErrorIs(t, err, ErrInvalidExpression, source)
+	}
+
+	parsed, err := parseExpression("a.count + {link-1.b}", "router.")
+	require.NoError(t, err)
+	require.Equal(t, binaryExpression{
+		operator: '+',
+		left:     referenceExpression("router.a.count"),
+		right:    referenceExpression("router.link-1.b"),
+	}, parsed)
+}
+
+func TestPercentileFieldRoundTrip(t *testing.T) {
+	for _, percentile := range []float64{0, 0.0001, 0.001, 0.005, 0.01, 0.05, 0.055, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.995, 0.999, 0.9999, 1} {
+		name := PercentileNameShort("latency", percentile)
+		field, found := strings.CutPrefix(name, "latency.")
+		require.True(t, found, name)
+		parsed, ok := parsePercentileField(field)
+		require.True(t, ok, name)
+		require.InDelta(t, percentile, parsed, 1e-12, name)
+	}
+
+	require.Equal(t, "latency.p05", PercentileNameShort("latency", 0.05))
+	require.Equal(t, "latency.p005", PercentileNameShort("latency", 0.005))
+	require.Equal(t, "latency.p999", PercentileNameShort("latency", 0.999))
+	require.Equal(t, "latency.p100", PercentileNameShort("latency", 1))
+
+	for _, field := range []string{"p", "p5", "p5x", "x50"} {
+		_, ok := parsePercentileField(field)
+		require.False(t, ok, field)
+	}
+}
+
+func TestRatioGauge(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	errors := registry.Counter("errors")
+	defer errors.Dispose()
+	requests := registry.Meter("requests")
+	defer requests.Dispose()
+
+	ratio := registry.RatioGauge("error.ratio", "errors", "requests.count")
+	require.Equal(t, MetricTypeGaugeFloat64, MetricTypeOf(ratio))
+
+	// division by zero isn't reported
+	require.True(t, math.IsNaN(ratio.Value()))
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.NotContains(t, visitor.floatGauge, "error.ratio")
+
+	errors.Inc(1)
+	requests.Mark(4)
+	require.Equal(t, 0.25, ratio.Value())
+
+	visitor = newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, 0.25, visitor.floatGauge["error.ratio"].Value())
+}
+
+func TestExpressionGaugeFields(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Gauge("queue").Update(4)
+	registry.GaugeFloat64("load").Update(0.5)
+	histogram := registry.Histogram("size")
+	defer histogram.Dispose()
+	timer := registry.Timer("latency", WithRateWindows(10*time.Second))
+	defer timer.Dispose()
+	operation := registry.Operation("dial")
+	defer operation.Dispose()
+
+	for i := int64(1); i <= 4; i++ {
+		histogram.Update(i * 10)
+		timer.Update(time.Duration(i) * time.Millisecond)
+	}
+	operation.Record(OutcomeSuccess, time.Second)
+
+	tests := map[string]float64{
+		"queue * load":                     2,
+		"size.count + size.min + size.max": 54,
+		"size.sum / size.count":            25,
+		"size.mean":                        25,
+		"size.p50":                         25,
+		"latency.max":                      float64(4 * time.Millisecond),
+		"latency.count":                    4,
+		"dial.max":                         float64(time.Second),
+		"dial.success.count":               1,
+		"dial.timeout.count":               0,
+	}
+	for source, expected := range tests {
+		gauge, err := registry.TryExpressionGauge("computed."+source, source)
+		require.NoError(t, err, source)
+		require.Equal(t, expected, gauge.Value(), source)
+	}
+
+	for _, source := range []string{"missing", "queue.count", "size.unknown", "latency.rate_10s + missing", "dial.unknown.count"} {
+		gauge, err := registry.TryExpressionGauge("computed."+source, source)
+		require.NoError(t, err, source)
+		require.True(t, math.IsNaN(gauge.Value()), source)
+	}
+
+	rate, err := registry.TryExpressionGauge("rate", "latency.rate_10s")
+	require.NoError(t, err)
+	require.False(t, math.IsNaN(rate.Value()))
+}
+
+func TestExpressionGaugeDisposedInput(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	requests := registry.Meter("requests")
+	requests.Mark(10)
+
+	gauge := registry.ExpressionGauge("doubled", "requests.count * 2")
+	require.Equal(t, float64(20), gauge.Value())
+
+	requests.Dispose()
+	require.True(t, math.IsNaN(gauge.Value()))
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Empty(t, visitor.floatGauge)
+}
+
+func TestExpressionGaugeCycle(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	a := registry.ExpressionGauge("a", "b + 1")
+	registry.ExpressionGauge("b", "a + 1")
+	require.True(t, math.IsNaN(a.Value()))
+
+	// cycles with many references to each other are detected without evaluating every path
+	fanOut := registry.ExpressionGauge("f", "g + g + g + g")
+	registry.ExpressionGauge("g", "f + f + f + f")
+	done := make(chan float64, 1)
+	go func() {
+		done <- fanOut.Value()
+	}()
+	select {
+	case value := <-done:
+		require.True(t, math.IsNaN(value))
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "evaluating a cycle didn't finish")
+	}
+
+	// computed gauges may reference each other, as long as they don't form a cycle
+	registry.Gauge("d").Update(3)
+	registry.ExpressionGauge("c", "d * 2")
+	require.Equal(t, float64(7), registry.ExpressionGauge("e", "c + 1").Value())
+	require.Equal(t, float64(24), registry.ExpressionGauge("h", "c + c + c + c").Value())
+}
+
+func TestExpressionGaugeRollupReference(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Gauge("link.a.queue").Update(3)
+	registry.Gauge("link.b.queue").Update(5)
+	first := registry.Meter("link.a.tx")
+	defer first.Dispose()
+	second := registry.Meter("link.b.tx")
+	defer second.Dispose()
+	first.Mark(1)
+	second.Mark(3)
+
+	registry.Rollup("links.queue", "link.*.queue", MetricTypeGauge, AggregationSum)
+	registry.Rollup("links.tx", "link.*.tx", MetricTypeMeter, AggregationSum)
+
+	gauge := registry.ExpressionGauge("average", "links.queue / links.tx.count")
+	require.Equal(t, float64(2), gauge.Value())
+}
+
+func TestExpressionGaugeRollupCycle(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.GaugeFloat64("g.a").Update(2)
+	registry.Rollup("total", "g.*", MetricTypeGaugeFloat64, AggregationSum)
+	registry.ExpressionGauge("g.b", "total + 1")
+
+	// g.b is matched by the rollup it references, so it references itself
+	require.True(t, math.IsNaN(registry.GetGaugeFloat64("g.b").Value()))
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.NotContains(t, visitor.floatGauge, "g.b")
+	require.NotContains(t, visitor.floatGauge, "total")
+	require.Equal(t, float64(2), visitor.floatGauge["g.a"].Value())
+}
+
+func TestInvalidExpressionGauge(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	_, err := registry.TryExpressionGauge("gauge", "requests.count *")
+	require.ErrorIs(t, err, ErrInvalidExpression)
+	require.False(t, registry.IsValidMetric("gauge"))
+
+	registry.Meter("meter")
+	_, err = registry.TryRatioGauge("meter", "a", "b")
+	require.ErrorIs(t, err, ErrMetricTypeConflict)
+}
+
+func TestChildRegistryExpressionGauge(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	child := registry.Child("link", nil)
+	child.Gauge("tx").Update(3)
+	child.Gauge("rx").Update(6)
+
+	sum := child.ExpressionGauge("total", "tx + rx")
+	ratio := child.RatioGauge("ratio", "tx", "rx")
+	require.Equal(t, float64(9), sum.Value())
+	require.Equal(t, 0.5, ratio.Value())
+	require.True(t, registry.IsValidMetric("link.total"))
+
+	scope := NewScope(child)
+	scope.ExpressionGauge("scoped", "tx * 2")
+	require.True(t, registry.IsValidMetric("link.scoped"))
+	scope.Close()
+	require.False(t, registry.IsValidMetric("link.scoped"))
+}
@@ -13,9 +13,20 @@ type Gauge interface {
 
 type gaugeImpl struct {
 	metrics.Gauge
+	idleTracker
+	unit    Unit
 	dispose func()
 }
 
+func (gauge *gaugeImpl) Update(value int64) {
+	gauge.markUpdated()
+	gauge.Gauge.Update(value)
+}
+
+func (gauge *gaugeImpl) Unit() Unit {
+	return gauge.unit
+}
+
 func (gauge *gaugeImpl) Dispose() {
 	gauge.dispose()
 }
@@ -29,9 +40,42 @@ type GaugeFloat64 interface {
 
 type gaugeFloat64Impl struct {
 	metrics.GaugeFloat64
+	idleTracker
+	unit    Unit
 	dispose func()
 }
 
+func (gauge *gaugeFloat64Impl) Update(value float64) {
+	gauge.markUpdated()
+	gauge.GaugeFloat64.Update(value)
+}
+
+func (gauge *gaugeFloat64Impl) Unit() Unit {
+	return gauge.unit
+}
+
 func (gauge *gaugeFloat64Impl) Dispose() {
 	gauge.dispose()
 }
+
+// gaugeSnapshot is a read-only Gauge, used to visit values derived from other metrics
+type gaugeSnapshot struct {
+	value int64
+	unit  Unit
+}
+
+func (self *gaugeSnapshot) Value() int64 { return self.value }
+func (self *gaugeSnapshot) Update(int64) {}
+func (self *gaugeSnapshot) Unit() Unit   { return self.unit }
+func (self *gaugeSnapshot) Dispose()     {}
+
+// gaugeFloat64Snapshot is a read-only GaugeFloat64, used to visit values derived from other metrics
+type gaugeFloat64Snapshot struct {
+	value float64
+	unit  Unit
+}
+
+func (self *gaugeFloat64Snapshot) Value() float64 { return self.value }
+func (self *gaugeFloat64Snapshot) Update(float64) {}
+func (self *gaugeFloat64Snapshot) Unit() Unit     { return self.unit }
+func (self *gaugeFloat64Snapshot) Dispose()       {}
@@ -3,7 +3,6 @@ module github.com/openziti/metrics/v2
 go 1.25.0
 
 require (
-	github.com/openziti/foundation/v2 v2.0.98
 	github.com/orcaman/concurrent-map/v2 v2.0.1
 	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0
 	github.com/stretchr/testify v1.11.1
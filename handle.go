@@ -0,0 +1,97 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Handle is a pre-resolved reference to a reference counted metric, for code which acquires the same metric
+// over and over, for example once per connection. Acquire returns the same metric, with a reference taken,
+// as the registry would, but while the metric is in use it does so without looking its name up in the
+// registry and without allocating. Each metric returned by Acquire must be disposed, as usual.
+//
+// A Handle is safe for concurrent use. Handles for registries not created by this package always go
+// through the registry.
+type Handle[T Metric] struct {
+	root    *registryImpl
+	get     func() T
+	current atomic.Pointer[handleEntry[T]]
+}
+
+type handleEntry[T Metric] struct {
+	metric T
+	rc     refCounted
+}
+
+func newHandle[T Metric](registry Registry, get func() T) *Handle[T] {
+	result := &Handle[T]{get: get}
+	switch r := registry.(type) {
+	case *registryImpl:
+		result.root = r
+	case *childRegistry:
+		result.root = r.root
+	}
+	return result
+}
+
+// NewMeterHandle returns a Handle which acquires the named meter from the given registry
+func NewMeterHandle(registry Registry, name string, options ...MetricOption) *Handle[Meter] {
+	return newHandle(registry, func() Meter {
+		return registry.Meter(name, options...)
+	})
+}
+
+// NewHistogramHandle returns a Handle which acquires the named histogram from the given registry
+func NewHistogramHandle(registry Registry, name string, options ...MetricOption) *Handle[Histogram] {
+	return newHandle(registry, func() Histogram {
+		return registry.Histogram(name, options...)
+	})
+}
+
+// NewCounterHandle returns a Handle which acquires the named counter from the given registry
+func NewCounterHandle(registry Registry, name string, options ...MetricOption) *Handle[Counter] {
+	return newHandle(registry, func() Counter {
+		return registry.Counter(name, options...)
+	})
+}
+
+// NewWindowCounterHandle returns a Handle which acquires the named window counter from the given registry
+func NewWindowCounterHandle(registry Registry, name string, window time.Duration, buckets int, options ...MetricOption) *Handle[WindowCounter] {
+	return newHandle(registry, func() WindowCounter {
+		return registry.WindowCounter(name, window, buckets, options...)
+	})
+}
+
+// Acquire returns the metric, with a reference taken. Once all references to the cached metric have been
+// disposed, or it has been removed from the registry, the metric is looked up in the registry again
+func (self *Handle[T]) Acquire() T {
+	if entry := self.current.Load(); entry != nil {
+		if refCount, ok := entry.rc.tryIncrRefCount(); ok {
+			self.root.reacquired(entry.rc.Name(), entry.rc, refCount)
+			return entry.metric
+		}
+	}
+
+	metric := self.get()
+	// no-op metrics, returned on errors, aren't reference counted and aren't cached
+	if rc, ok := any(metric).(refCounted); ok && self.root != nil {
+		self.current.Store(&handleEntry[T]{metric: metric, rc: rc})
+	}
+	return metric
+}
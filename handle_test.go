@@ -0,0 +1,242 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleAcquiresCachedMetric(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	handle := NewMeterHandle(registry, "conn.rx")
+
+	first := handle.Acquire()
+	second := handle.Acquire()
+	third := registry.Meter("conn.rx")
+	require.Same(t, first, second)
+	require.Same(t, first, third)
+
+	first.Dispose()
+	second.Dispose()
+	require.True(t, registry.IsValidMetric("conn.rx"))
+
+	third.Dispose()
+	require.False(t, registry.IsValidMetric("conn.rx"))
+}
+
+func TestHandleReacquiresDisposedMetric(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	handle := NewCounterHandle(registry, "conn.count")
+
+	first := handle.Acquire()
+	first.Inc(5)
+	first.Dispose()
+	require.False(t, registry.IsValidMetric("conn.count"))
+
+	second := handle.Acquire()
+	require.NotSame(t, first, second)
+	require.True(t, registry.IsValidMetric("conn.count"))
+	require.Equal(t, int64(0), second.Count())
+
+	second.Dispose()
+	require.False(t, registry.IsValidMetric("conn.count"))
+}
+
+func TestHandleReacquiresRemovedMetric(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	handle := NewHistogramHandle(registry, "latency")
+
+	first := handle.Acquire()
+	registry.DisposeAll()
+
+	second := handle.Acquire()
+	require.NotSame(t, first, second)
+	require.True(t, registry.IsValidMetric("latency"))
+
+	// disposing the reference to the removed histogram must not affect its replacement
+	first.Dispose()
+	require.True(t, registry.IsValidMetric("latency"))
+	second.Dispose()
+	require.False(t, registry.IsValidMetric("latency"))
+}
+
+func TestHandleWithChildRegistry(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	handle := NewHistogramHandle(registry.Child("child", nil), "histogram")
+
+	first := handle.Acquire()
+	second := handle.Acquire()
+	require.Same(t, first, second)
+
+	listener := &collectingListener{}
+	registry.AddListener(listener)
+	third := handle.Acquire()
+	require.Same(t, first, registry.GetHistogram("child.histogram"))
+	require.Len(t, listener.events, 1)
+	require.Equal(t, RegistryEvent{
+		Type:       RegistryEventRefCountChanged,
+		Name:       "child.histogram",
+		MetricType: MetricTypeHistogram,
+		RefCount:   3,
+	}, listener.events[0])
+
+	for _, histogram := range []Histogram{first, second, third} {
+		histogram.Dispose()
+	}
+	require.False(t, registry.IsValidMetric("child.histogram"))
+}
+
+func TestHandleRefCountTracking(t *testing.T) {
+	registry := NewRegistry("test", nil, WithRefCountTracking(0))
+	handle := NewMeterHandle(registry, "meter")
+
+	first := handle.Acquire()
+	second := handle.Acquire()
+	registry.RefCountCheckpoint()
+
+	report := registry.RefCountReport()
+	require.Len(t, report.Entries, 1)
+	require.Len(t, report.Entries[0].Acquisitions, 2)
+	require.Contains(t, report.Entries[0].Acquisitions[1], "TestHandleRefCountTracking")
+
+	second.Dispose()
+	first.Dispose()
+	AssertNoLeakedMetrics(t, registry)
+}
+
+func TestHandleConcurrentAcquire(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	handle := NewMeterHandle(registry, "meter")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				meter := handle.Acquire()
+				meter.Mark(1)
+				meter.Dispose()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.False(t, registry.IsValidMetric("meter"))
+}
+
+func TestHandleAcquireDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations can't be counted with the race detector enabled")
+	}
+	registry := NewRegistry("test", nil)
+	handle := NewMeterHandle(registry, "conn.rx")
+	held := handle.Acquire()
+	defer held.Dispose()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		meter := handle.Acquire()
+		meter.Mark(1)
+		meter.Dispose()
+	})
+	require.Zero(t, allocs)
+}
+
+// discardingSink accepts everything and keeps nothing, so it doesn't add allocations of its own
+type discardingSink struct{}
+
+func (discardingSink) Filter(string) bool                              { return true }
+func (discardingSink) StartReport(Registry)                            {}
+func (discardingSink) EndReport(Registry)                              {}
+func (discardingSink) AcceptIntMetric(string, int64)                   {}
+func (discardingSink) AcceptFloatMetric(string, float64)               {}
+func (discardingSink) AcceptPercentileMetric(string, PercentileSource) {}
+
+func newReportingRegistry(metrics int) Registry {
+	registry := NewRegistry("test", nil, WithNativeMeters())
+	for i := 0; i < metrics; i++ {
+		registry.Meter(fmt.Sprintf("meter.%d", i)).Mark(int64(i))
+		registry.Gauge(fmt.Sprintf("gauge.%d", i)).Update(int64(i))
+		registry.Counter(fmt.Sprintf("counter.%d", i)).Inc(int64(i))
+	}
+	return registry
+}
+
+func TestReportDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations can't be counted with the race detector enabled")
+	}
+	reporter := NewDelegatingReporter(newReportingRegistry(10), discardingSink{}, nil)
+	// the first report builds and caches the reported names
+	reporter.Report()
+
+	allocs := testing.AllocsPerRun(10, reporter.Report)
+	require.Zero(t, allocs)
+}
+
+func TestReportNameCacheDropsUnusedNames(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	reporter := NewDelegatingReporter(registry, discardingSink{}, nil)
+
+	registry.Meter("meter").Mark(1)
+	reporter.Report()
+	require.Contains(t, reporter.names.previous, reportedName{kind: reportedNameSuffixed, name: "meter", extra: MetricNameCount})
+
+	registry.DisposeAll()
+	reporter.Report()
+	reporter.Report()
+	require.Empty(t, reporter.names.previous)
+	require.Empty(t, reporter.names.current)
+}
+
+func BenchmarkAcquireMeter(b *testing.B) {
+	registry := NewRegistry("test", nil)
+	held := registry.Meter("conn.rx")
+	defer held.Dispose()
+
+	b.Run("registry", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			registry.Meter("conn.rx").Dispose()
+		}
+	})
+
+	b.Run("handle", func(b *testing.B) {
+		handle := NewMeterHandle(registry, "conn.rx")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handle.Acquire().Dispose()
+		}
+	})
+}
+
+// BenchmarkReport measures a full report cycle. Meters, counters and gauges are reported without
+// allocating. Histograms and timers are copied into a snapshot on every report, which always allocates.
+func BenchmarkReport(b *testing.B) {
+	reporter := NewDelegatingReporter(newReportingRegistry(1000), discardingSink{}, nil)
+	reporter.Report()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reporter.Report()
+	}
+}
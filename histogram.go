@@ -17,7 +17,6 @@
 package metrics
 
 import (
-	"github.com/openziti/foundation/v2/concurrenz"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -42,14 +41,25 @@ type Histogram interface {
 type histogramImpl struct {
 	metrics.Histogram
 	name     string
+	unit     Unit
 	registry *registryImpl
-	concurrenz.RefCount
+	refCount
+	idleTracker
+}
+
+func (self *histogramImpl) Update(value int64) {
+	self.markUpdated()
+	self.Histogram.Update(value)
 }
 
 func (self *histogramImpl) Name() string {
 	return self.name
 }
 
+func (self *histogramImpl) Unit() Unit {
+	return self.unit
+}
+
 func (self *histogramImpl) Dispose() {
 	self.registry.disposeRefCounted(self)
 }
@@ -62,18 +72,24 @@ func (self *histogramImpl) CreateSnapshot() Histogram {
 	return &histogramSnapshot{
 		Histogram: self.Snapshot(),
 		name:      self.name,
+		unit:      self.unit,
 	}
 }
 
 type histogramSnapshot struct {
 	metrics.Histogram
 	name string
+	unit Unit
 }
 
 func (self *histogramSnapshot) Name() string {
 	return self.name
 }
 
+func (self *histogramSnapshot) Unit() Unit {
+	return self.unit
+}
+
 func (self *histogramSnapshot) Dispose() {}
 
 func (self *histogramSnapshot) CreateSnapshot() Histogram {
@@ -0,0 +1,234 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const maxTrackedStackDepth = 32
+
+// WithRefCountTracking enables a debug mode which records the call stack of each acquisition of a reference
+// counted metric, such as a Meter or Histogram, so leaked references can be found with RefCountReport. If
+// maxRefCount is greater than zero, metrics with more outstanding references are reported. Capturing
+// stacks is expensive, so this is intended for tests and debugging.
+func WithRefCountTracking(maxRefCount int32) RegistryOption {
+	return func(registry *registryImpl) {
+		registry.refCountTracker = &refCountTracker{
+			maxRefCount: maxRefCount,
+			metrics:     map[string]*trackedRefCounts{},
+		}
+	}
+}
+
+// RefCountReport lists reference counted metrics which may have been leaked
+type RefCountReport struct {
+	// Enabled is false if the registry wasn't created with WithRefCountTracking, in which case the report is empty
+	Enabled bool
+	Entries []RefCountReportEntry
+}
+
+// RefCountReportEntry describes a metric with suspicious outstanding references
+type RefCountReportEntry struct {
+	Name       string
+	MetricType MetricType
+	RefCount   int32
+	// ExceedsMax is true if the metric has more references than the maximum given to WithRefCountTracking
+	ExceedsMax bool
+	// SurvivedCheckpoint is true if the metric has had outstanding references since before the last
+	// checkpoint. Like Acquisitions, it's based on the oldest acquisitions, which aren't necessarily those
+	// of the references still held
+	SurvivedCheckpoint bool
+	// Acquisitions holds one call stack for each outstanding reference, oldest first. All references to a
+	// metric share the same handle, so a disposal can't be matched to the acquisition it releases, and
+	// drops the most recent acquisition instead. The stacks show where the metric was acquired, and where
+	// the leaked references may have come from, but not which of them are still held. For example, if a
+	// metric is acquired at A and then at B, and the reference from A is disposed, the stack of A is listed
+	Acquisitions []string
+}
+
+func (self RefCountReport) String() string {
+	builder := &strings.Builder{}
+	for _, entry := range self.Entries {
+		_, _ = fmt.Fprintf(builder, "%v %v has %v outstanding references (exceeds max: %v, survived checkpoint: %v)\n",
+			entry.MetricType, entry.Name, entry.RefCount, entry.ExceedsMax, entry.SurvivedCheckpoint)
+		for idx, stack := range entry.Acquisitions {
+			_, _ = fmt.Fprintf(builder, "  acquisition %v:\n%v", idx+1, stack)
+		}
+	}
+	return builder.String()
+}
+
+// TestingT is the subset of testing.TB used by AssertNoLeakedMetrics
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// AssertNoLeakedMetrics fails the test if the registry has outstanding references to reference counted
+// metrics. It takes a checkpoint, so any reference acquired up to now, and not yet disposed, is reported.
+// The registry must have been created with WithRefCountTracking.
+func AssertNoLeakedMetrics(t TestingT, registry Registry) {
+	t.Helper()
+	registry.RefCountCheckpoint()
+	report := registry.RefCountReport()
+	if !report.Enabled {
+		t.Errorf("registry %v doesn't have ref count tracking enabled", registry.SourceId())
+		return
+	}
+	if len(report.Entries) > 0 {
+		t.Errorf("registry %v has leaked metrics:\n%v", registry.SourceId(), report)
+	}
+}
+
+type refCountTracker struct {
+	sync.Mutex
+	maxRefCount int32
+	checkpoint  uint64
+	metrics     map[string]*trackedRefCounts
+}
+
+type trackedRefCounts struct {
+	metric       Metric
+	acquisitions []acquisition
+}
+
+type acquisition struct {
+	checkpoint uint64
+	stack      []uintptr
+}
+
+// acquired records an acquisition of the given metric. skip is the number of frames between the caller
+// of this function and the code acquiring the metric which should be left out of the stack. The metric
+// may have been removed since it was acquired, so registered is checked while the tracker is locked, and
+// the acquisition is dropped if the metric is no longer registered. Removals are recorded after the metric
+// has left the registry, so a removal is either seen by the check, or recorded after the acquisition
+func (self *refCountTracker) acquired(skip int, name string, metric Metric, registered func() bool) {
+	stack := make([]uintptr, maxTrackedStackDepth)
+	// skip runtime.Callers and this function, as well as the requested frames
+	stack = stack[:runtime.Callers(skip+2, stack)]
+
+	self.Lock()
+	defer self.Unlock()
+
+	if !registered() {
+		return
+	}
+
+	tracked := self.metrics[name]
+	if tracked == nil || tracked.metric != metric {
+		tracked = &trackedRefCounts{metric: metric}
+		self.metrics[name] = tracked
+	}
+	tracked.acquisitions = append(tracked.acquisitions, acquisition{
+		checkpoint: self.checkpoint,
+		stack:      stack,
+	})
+}
+
+// released drops the most recent acquisition of the given metric. The acquisition actually being released
+// isn't known, see RefCountReportEntry.Acquisitions
+func (self *refCountTracker) released(name string, metric Metric) {
+	self.Lock()
+	defer self.Unlock()
+
+	if tracked := self.metrics[name]; tracked != nil && tracked.metric == metric && len(tracked.acquisitions) > 0 {
+		tracked.acquisitions = tracked.acquisitions[:len(tracked.acquisitions)-1]
+	}
+}
+
+func (self *refCountTracker) removed(name string, metric Metric) {
+	self.Lock()
+	defer self.Unlock()
+
+	if tracked := self.metrics[name]; tracked != nil && tracked.metric == metric {
+		delete(self.metrics, name)
+	}
+}
+
+func (self *refCountTracker) takeCheckpoint() {
+	self.Lock()
+	defer self.Unlock()
+	self.checkpoint++
+}
+
+func (self *refCountTracker) report(prefix string) RefCountReport {
+	self.Lock()
+	defer self.Unlock()
+
+	result := RefCountReport{Enabled: true}
+	for name, tracked := range self.metrics {
+		if !strings.HasPrefix(name, prefix) || len(tracked.acquisitions) == 0 {
+			continue
+		}
+
+		refCount := int32(len(tracked.acquisitions))
+		entry := RefCountReportEntry{
+			Name:               name,
+			MetricType:         MetricTypeOf(tracked.metric),
+			RefCount:           refCount,
+			ExceedsMax:         self.maxRefCount > 0 && refCount > self.maxRefCount,
+			SurvivedCheckpoint: tracked.acquisitions[0].checkpoint < self.checkpoint,
+		}
+		if !entry.ExceedsMax && !entry.SurvivedCheckpoint {
+			continue
+		}
+		for _, acq := range tracked.acquisitions {
+			entry.Acquisitions = append(entry.Acquisitions, formatStack(acq.stack))
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	sort.Slice(result.Entries, func(i, j int) bool {
+		return result.Entries[i].Name < result.Entries[j].Name
+	})
+	return result
+}
+
+func formatStack(stack []uintptr) string {
+	builder := &strings.Builder{}
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		_, _ = fmt.Fprintf(builder, "    %v\n        %v:%v\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return builder.String()
+}
+
+func (registry *registryImpl) RefCountCheckpoint() {
+	if registry.refCountTracker != nil {
+		registry.refCountTracker.takeCheckpoint()
+	}
+}
+
+func (registry *registryImpl) RefCountReport() RefCountReport {
+	return registry.refCountReport("")
+}
+
+func (registry *registryImpl) refCountReport(prefix string) RefCountReport {
+	if registry.refCountTracker == nil {
+		return RefCountReport{}
+	}
+	return registry.refCountTracker.report(prefix)
+}
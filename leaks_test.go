@@ -0,0 +1,106 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (self *recordingT) Helper() {}
+
+func (self *recordingT) Errorf(format string, args ...any) {
+	self.errors = append(self.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRefCountReport(t *testing.T) {
+	registry := NewRegistry("test", nil, WithRefCountTracking(2))
+
+	meter := registry.Meter("meter")
+	registry.Meter("meter")
+	registry.Meter("meter")
+	histogram := registry.Histogram("histogram")
+
+	report := registry.RefCountReport()
+	require.True(t, report.Enabled)
+	require.Len(t, report.Entries, 1)
+	require.Equal(t, "meter", report.Entries[0].Name)
+	require.Equal(t, int32(3), report.Entries[0].RefCount)
+	require.True(t, report.Entries[0].ExceedsMax)
+	require.Len(t, report.Entries[0].Acquisitions, 3)
+	require.Contains(t, report.Entries[0].Acquisitions[0], "TestRefCountReport")
+
+	meter.Dispose()
+	registry.RefCountCheckpoint()
+	report = registry.RefCountReport()
+	require.Len(t, report.Entries, 2)
+	require.True(t, report.Entries[0].SurvivedCheckpoint)
+	require.Equal(t, "histogram", report.Entries[0].Name)
+
+	meter.Dispose()
+	meter.Dispose()
+	histogram.Dispose()
+	require.Empty(t, registry.RefCountReport().Entries)
+}
+
+func TestRefCountReportAcquiredAfterRemoval(t *testing.T) {
+	registry := NewRegistry("test", nil, WithRefCountTracking(1)).(*registryImpl)
+	meter := registry.Meter("meter")
+	registry.Unregister("meter")
+
+	// an acquisition which is recorded after the metric's removal doesn't leave an entry behind
+	registry.trackAcquired(0, "meter", meter)
+	require.Empty(t, registry.RefCountReport().Entries)
+
+	// nor does one of a metric which has since been replaced, which would hide the replacement's references
+	replacement := registry.Meter("meter")
+	registry.Meter("meter")
+	registry.trackAcquired(0, "meter", meter)
+	report := registry.RefCountReport()
+	require.Len(t, report.Entries, 1)
+	require.Equal(t, int32(2), report.Entries[0].RefCount)
+	replacement.Dispose()
+	replacement.Dispose()
+}
+
+func TestAssertNoLeakedMetrics(t *testing.T) {
+	registry := NewRegistry("test", nil, WithRefCountTracking(0))
+	child := registry.Child("child", nil)
+
+	registry.Meter("meter")
+	histogram := child.Histogram("histogram")
+
+	recorder := &recordingT{}
+	AssertNoLeakedMetrics(recorder, child)
+	require.Len(t, recorder.errors, 1)
+	require.Contains(t, recorder.errors[0], "child.histogram")
+	require.NotContains(t, recorder.errors[0], "meter")
+
+	histogram.Dispose()
+	recorder = &recordingT{}
+	AssertNoLeakedMetrics(recorder, child)
+	require.Empty(t, recorder.errors)
+
+	AssertNoLeakedMetrics(recorder, NewRegistry("untracked", nil))
+	require.Len(t, recorder.errors, 1)
+}
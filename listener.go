@@ -0,0 +1,142 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// RegistryEventType identifies what happened in a RegistryEvent
+type RegistryEventType string
+
+const (
+	// RegistryEventCreated is sent when a metric is added to the registry
+	RegistryEventCreated RegistryEventType = "created"
+	// RegistryEventRefCountChanged is sent when a reference counted metric is acquired again, or when a
+	// reference is disposed without removing the metric
+	RegistryEventRefCountChanged RegistryEventType = "ref_count_changed"
+	// RegistryEventDisposed is sent when a metric is removed from the registry, whether it was disposed,
+	// unregistered, evicted or removed by DisposeAll
+	RegistryEventDisposed RegistryEventType = "disposed"
+	// RegistryEventDisposeAll is sent after DisposeAll has removed metrics. The event name is the prefix of
+	// the metrics which were removed, which is empty for the whole registry
+	RegistryEventDisposeAll RegistryEventType = "dispose_all"
+)
+
+// RegistryEvent describes a change to the metrics in a registry
+type RegistryEvent struct {
+	Type RegistryEventType
+	// Name is the full name of the metric
+	Name string
+	// MetricType is the type of the metric, which is empty for metrics which aren't one of this package's types
+	MetricType MetricType
+	// RefCount is the reference count of a reference counted metric after the change, and 0 for other metrics
+	RefCount int32
+}
+
+// RegistryListener is notified of changes to the metrics in a registry. Listeners are called synchronously,
+// on the goroutine making the change, so they should be quick and must not block
+type RegistryListener interface {
+	AcceptRegistryEvent(event RegistryEvent)
+}
+
+// RegistryListenerF adapts a function to a RegistryListener. As functions can't be compared, a listener
+// created this way can't be removed, and passing it to RemoveListener has no effect; use a pointer to a
+// type implementing RegistryListener if it needs to be
+type RegistryListenerF func(event RegistryEvent)
+
+func (self RegistryListenerF) AcceptRegistryEvent(event RegistryEvent) {
+	self(event)
+}
+
+type listenerEntry struct {
+	prefix   string
+	listener RegistryListener
+}
+
+func (self *listenerEntry) accepts(event RegistryEvent) bool {
+	if event.Type == RegistryEventDisposeAll {
+		return strings.HasPrefix(event.Name, self.prefix) || strings.HasPrefix(self.prefix, event.Name)
+	}
+	return strings.HasPrefix(event.Name, self.prefix)
+}
+
+func (registry *registryImpl) AddListener(listener RegistryListener) {
+	registry.addListener("", listener)
+}
+
+func (registry *registryImpl) RemoveListener(listener RegistryListener) {
+	registry.removeListener("", listener)
+}
+
+// addListener registers a listener for events about metrics whose names start with the given prefix
+func (registry *registryImpl) addListener(prefix string, listener RegistryListener) {
+	registry.listenersLock.Lock()
+	defer registry.listenersLock.Unlock()
+
+	var listeners []*listenerEntry
+	if current := registry.listeners.Load(); current != nil {
+		listeners = slices.Clone(*current)
+	}
+	listeners = append(listeners, &listenerEntry{prefix: prefix, listener: listener})
+	registry.listeners.Store(&listeners)
+}
+
+func (registry *registryImpl) removeListener(prefix string, listener RegistryListener) {
+	registry.listenersLock.Lock()
+	defer registry.listenersLock.Unlock()
+
+	current := registry.listeners.Load()
+	if current == nil {
+		return
+	}
+	listeners := slices.DeleteFunc(slices.Clone(*current), func(entry *listenerEntry) bool {
+		return entry.prefix == prefix && sameListener(entry.listener, listener)
+	})
+	registry.listeners.Store(&listeners)
+}
+
+// sameListener compares listeners without panicking on listeners which can't be compared, such as
+// RegistryListenerF, which are never the same as any other listener
+func sameListener(a, b RegistryListener) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Type() == vb.Type() && va.Comparable() && vb.Comparable() && a == b
+}
+
+func (registry *registryImpl) notify(eventType RegistryEventType, name string, metric Metric, refCount int32) {
+	listeners := registry.listeners.Load()
+	if listeners == nil || len(*listeners) == 0 {
+		return
+	}
+
+	event := RegistryEvent{
+		Type:     eventType,
+		Name:     name,
+		RefCount: refCount,
+	}
+	if metric != nil {
+		event.MetricType = MetricTypeOf(metric)
+	}
+
+	for _, entry := range *listeners {
+		if entry.accepts(event) {
+			entry.listener.AcceptRegistryEvent(event)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type collectingListener struct {
+	events []RegistryEvent
+}
+
+func (self *collectingListener) AcceptRegistryEvent(event RegistryEvent) {
+	self.events = append(self.events, event)
+}
+
+func TestRegistryListener(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	listener := &collectingListener{}
+	registry.AddListener(listener)
+
+	meter := registry.Meter("meter")
+	registry.Meter("meter")
+	meter.Dispose()
+	meter.Dispose()
+	gauge := registry.Gauge("gauge")
+	gauge.Dispose()
+
+	require.Equal(t, []RegistryEvent{
+		{Type: RegistryEventCreated, Name: "meter", MetricType: MetricTypeMeter, RefCount: 1},
+		{Type: RegistryEventRefCountChanged, Name: "meter", MetricType: MetricTypeMeter, RefCount: 2},
+		{Type: RegistryEventRefCountChanged, Name: "meter", MetricType: MetricTypeMeter, RefCount: 1},
+		{Type: RegistryEventDisposed, Name: "meter", MetricType: MetricTypeMeter},
+		{Type: RegistryEventCreated, Name: "gauge", MetricType: MetricTypeGauge},
+		{Type: RegistryEventDisposed, Name: "gauge", MetricType: MetricTypeGauge},
+	}, listener.events)
+
+	registry.RemoveListener(listener)
+	registry.Timer("timer")
+	require.Len(t, listener.events, 6)
+}
+
+func TestChildRegistryListener(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	child := registry.Child("child", nil)
+	listener := &collectingListener{}
+	child.AddListener(listener)
+
+	registry.Histogram("other")
+	child.Histogram("histogram")
+	child.DisposeAll()
+	registry.DisposeAll()
+
+	require.Equal(t, []RegistryEvent{
+		{Type: RegistryEventCreated, Name: "child.histogram", MetricType: MetricTypeHistogram, RefCount: 1},
+		{Type: RegistryEventDisposed, Name: "child.histogram", MetricType: MetricTypeHistogram},
+		{Type: RegistryEventDisposeAll, Name: "child."},
+		{Type: RegistryEventDisposeAll, Name: ""},
+	}, listener.events)
+}
+
+func TestRemoveUncomparableListener(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	var events []RegistryEvent
+	listener := RegistryListenerF(func(event RegistryEvent) {
+		events = append(events, event)
+	})
+	registry.AddListener(listener)
+
+	require.NotPanics(t, func() {
+		registry.RemoveListener(listener)
+		registry.RemoveListener(RegistryListenerF(func(RegistryEvent) {}))
+	})
+
+	registry.Gauge("gauge")
+	require.Len(t, events, 1)
+}
@@ -0,0 +1,399 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// MergeHistograms combines histograms, for example the per-link latency histograms of a router, into a
+// single read-only histogram snapshot. The inputs may be live histograms or snapshots, from any registry,
+// and may themselves be the result of a merge. All inputs must have the same unit.
+//
+// The statistics of the result are computed as follows:
+//
+//   - Count is the sum of the counts, and is exact.
+//   - Min and Max are the smallest and largest of the inputs' values, so they're as accurate as the inputs.
+//   - Mean and Variance combine the inputs' values, weighting each input by its count. Sum is the sum of
+//     the inputs' sums, so it has the same meaning as the inputs' Sum.
+//   - Percentiles are computed from a merged sample. Each input's sample stands for Count events, so values
+//     are drawn from the inputs in proportion to their counts, evenly spread over each input's sorted values,
+//     into a sample as large as the largest input sample. If every input's sample still holds all of its
+//     values, which is the case for inputs with fewer updates than their reservoir size, the samples are
+//     simply combined and percentiles are exact.
+//
+// How well merged percentiles represent the combined distribution depends on the inputs' reservoirs.
+// Uniform samples, as created by metrics.NewUniformSample, are unbiased, so merged percentiles have the
+// error of a uniform sample of the merged size. Histograms and timers created by a registry use
+// exponentially decaying samples, which favor the last five minutes or so of values, while counts cover the
+// histogram's lifetime. Merged percentiles therefore describe recent values, weighted by each input's
+// lifetime count, so an input which was busy in the past and is idle now is overrepresented.
+func MergeHistograms(histograms ...Histogram) (Histogram, error) {
+	unit, err := mergedUnit(histograms)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]Histogram, 0, len(histograms))
+	for _, histogram := range histograms {
+		sources = append(sources, histogram.CreateSnapshot())
+	}
+	return mergeHistograms(sources, unit)
+}
+
+// MergeTimers combines timers into a single read-only timer snapshot. Durations are merged as described for
+// MergeHistograms. Rates are summed, which is exact, as the inputs count separate events. Rates over custom
+// windows are only available for windows which all inputs have.
+func MergeTimers(timers ...Timer) (Timer, error) {
+	sources := make([]Timer, 0, len(timers))
+	histograms := make([]Histogram, 0, len(timers))
+	for _, timer := range timers {
+		snapshot := timer.CreateSnapshot()
+		sources = append(sources, snapshot)
+		histograms = append(histograms, timerHistogram{Timer: snapshot})
+	}
+
+	histogram, err := mergeHistograms(histograms, UnitNanoseconds)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &mergedTimer{
+		mergedHistogram: histogram,
+	}
+	for i, timer := range sources {
+		result.rate1 += timer.Rate1()
+		result.rate5 += timer.Rate5()
+		result.rate15 += timer.Rate15()
+		result.rateMean += timer.RateMean()
+
+		windows := RateWindows(timer)
+		if i == 0 {
+			for _, window := range windows {
+				result.windows = append(result.windows, windowedRate{window: window})
+			}
+		} else {
+			result.windows = slices.DeleteFunc(result.windows, func(windowed windowedRate) bool {
+				return !slices.Contains(windows, windowed.window)
+			})
+		}
+	}
+
+	for i := range result.windows {
+		for _, timer := range sources {
+			result.windows[i].rate += Rate(timer, result.windows[i].window)
+		}
+	}
+	return result, nil
+}
+
+func mergedUnit[T Metric](items []T) (Unit, error) {
+	if len(items) == 0 {
+		return UnitNone, nil
+	}
+	unit := UnitOf(items[0])
+	for _, item := range items[1:] {
+		if other := UnitOf(item); other != unit {
+			return UnitNone, fmt.Errorf("%w: units %v and %v differ", ErrNotMergeable, unit, other)
+		}
+	}
+	return unit, nil
+}
+
+type sampled interface {
+	Sample() metrics.Sample
+}
+
+// sampleOf returns the sample backing the given histogram or timer snapshot, or nil if it isn't available
+func sampleOf(metric any) metrics.Sample {
+	for {
+		if s, ok := metric.(sampled); ok {
+			return s.Sample()
+		}
+		switch m := metric.(type) {
+		case timerHistogram:
+			metric = m.Timer
+		case *timerSnapshot:
+			metric = m.Timer
+		case *timerAdapter:
+			metric = m.Timer
+		default:
+			return nil
+		}
+	}
+}
+
+// mergeSource holds the statistics and the sorted sample values of an input to a merge
+type mergeSource struct {
+	count    int64
+	mean     float64
+	variance float64
+	values   []int64
+}
+
+func mergeHistograms(histograms []Histogram, unit Unit) (*mergedHistogram, error) {
+	result := &mergedHistogram{
+		unit: unit,
+		min:  math.MaxInt64,
+		max:  math.MinInt64,
+	}
+
+	var sources []mergeSource
+	var totalCount int64
+	var weightedSum float64
+	for _, histogram := range histograms {
+		count := histogram.Count()
+		if count == 0 {
+			continue
+		}
+
+		sample := sampleOf(histogram)
+		if sample == nil {
+			return nil, fmt.Errorf("%w: the sample of %T isn't available", ErrNotMergeable, histogram)
+		}
+		values := sample.Values()
+		slices.Sort(values)
+		sources = append(sources, mergeSource{
+			count:    count,
+			mean:     histogram.Mean(),
+			variance: histogram.Variance(),
+			values:   values,
+		})
+
+		totalCount += count
+		weightedSum += float64(count) * histogram.Mean()
+		result.sum += histogram.Sum()
+		if len(values) > 0 {
+			result.min = min(result.min, histogram.Min())
+			result.max = max(result.max, histogram.Max())
+		}
+	}
+
+	if totalCount == 0 {
+		result.min, result.max = 0, 0
+		result.SampleSnapshot = metrics.NewSampleSnapshot(0, nil)
+		return result, nil
+	}
+
+	result.mean = weightedSum / float64(totalCount)
+	var weightedVariance float64
+	for _, source := range sources {
+		deviation := source.mean - result.mean
+		weightedVariance += float64(source.count) * (source.variance + deviation*deviation)
+	}
+	result.variance = weightedVariance / float64(totalCount)
+	if result.min > result.max {
+		result.min, result.max = 0, 0
+	}
+
+	result.SampleSnapshot = metrics.NewSampleSnapshot(totalCount, mergeSamples(sources, totalCount))
+	return result, nil
+}
+
+// mergeSamples draws values from each source in proportion to its count, see MergeHistograms
+func mergeSamples(sources []mergeSource, totalCount int64) []int64 {
+	complete := true
+	size := 0
+	for _, source := range sources {
+		complete = complete && int64(len(source.values)) == source.count
+		size = max(size, len(source.values))
+	}
+
+	var result []int64
+	if complete {
+		for _, source := range sources {
+			result = append(result, source.values...)
+		}
+		slices.Sort(result)
+		return result
+	}
+
+	// give each source its share of the merged sample, handing the slots lost to rounding down to the
+	// sources with the largest remainders, so the shares add up to the sample size
+	shares := make([]int, len(sources))
+	remainders := make([]float64, len(sources))
+	assigned := 0
+	for i, source := range sources {
+		if len(source.values) == 0 {
+			remainders[i] = -1
+			continue
+		}
+		exact := float64(size) * float64(source.count) / float64(totalCount)
+		shares[i] = int(exact)
+		remainders[i] = exact - float64(shares[i])
+		assigned += shares[i]
+	}
+	for ; assigned < size; assigned++ {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		if remainders[best] < 0 {
+			break
+		}
+		shares[best]++
+		remainders[best] = -1
+	}
+
+	result = make([]int64, 0, size)
+	for i, source := range sources {
+		for j := 0; j < shares[i]; j++ {
+			idx := int((float64(j) + 0.5) * float64(len(source.values)) / float64(shares[i]))
+			result = append(result, source.values[idx])
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// timerHistogram presents the durations of a timer snapshot as a histogram, so they can be merged
+type timerHistogram struct {
+	Timer
+}
+
+func (self timerHistogram) Clear() {
+	panic("Clear called on a timer snapshot")
+}
+
+func (self timerHistogram) Update(int64) {
+	panic("Update called on a timer snapshot")
+}
+
+func (self timerHistogram) CreateSnapshot() Histogram {
+	return self
+}
+
+// mergedHistogram is the read-only result of MergeHistograms. Percentiles come from the merged sample,
+// while the other statistics are computed from the inputs
+type mergedHistogram struct {
+	*metrics.SampleSnapshot
+	unit     Unit
+	min      int64
+	max      int64
+	sum      int64
+	mean     float64
+	variance float64
+}
+
+func (self *mergedHistogram) Min() int64 {
+	return self.min
+}
+
+func (self *mergedHistogram) Max() int64 {
+	return self.max
+}
+
+func (self *mergedHistogram) Sum() int64 {
+	return self.sum
+}
+
+func (self *mergedHistogram) Mean() float64 {
+	return self.mean
+}
+
+func (self *mergedHistogram) Variance() float64 {
+	return self.variance
+}
+
+func (self *mergedHistogram) StdDev() float64 {
+	return math.Sqrt(self.variance)
+}
+
+// Sample returns the merged sample, so merged histograms can be merged again
+func (self *mergedHistogram) Sample() metrics.Sample {
+	return self.SampleSnapshot
+}
+
+func (self *mergedHistogram) Unit() Unit {
+	return self.unit
+}
+
+func (self *mergedHistogram) Dispose() {}
+
+func (self *mergedHistogram) CreateSnapshot() Histogram {
+	return self
+}
+
+// mergedTimer is the read-only result of MergeTimers
+type mergedTimer struct {
+	*mergedHistogram
+	rate1    float64
+	rate5    float64
+	rate15   float64
+	rateMean float64
+	windows  []windowedRate
+}
+
+func (self *mergedTimer) Rate1() float64 {
+	return self.rate1
+}
+
+func (self *mergedTimer) Rate5() float64 {
+	return self.rate5
+}
+
+func (self *mergedTimer) Rate15() float64 {
+	return self.rate15
+}
+
+func (self *mergedTimer) RateMean() float64 {
+	return self.rateMean
+}
+
+func (self *mergedTimer) Rate(window time.Duration) float64 {
+	for _, windowed := range self.windows {
+		if windowed.window == window {
+			return windowed.rate
+		}
+	}
+	return standardRate(self, window)
+}
+
+func (self *mergedTimer) RateWindows() []time.Duration {
+	var result []time.Duration
+	for _, windowed := range self.windows {
+		result = append(result, windowed.window)
+	}
+	return result
+}
+
+func (self *mergedTimer) Time(func()) {
+	panic("Time called on a timer snapshot")
+}
+
+func (self *mergedTimer) Update(time.Duration) {
+	panic("Update called on a timer snapshot")
+}
+
+func (self *mergedTimer) UpdateSince(time.Time) {
+	panic("UpdateSince called on a timer snapshot")
+}
+
+func (self *mergedTimer) Unit() Unit {
+	return UnitNanoseconds
+}
+
+func (self *mergedTimer) CreateSnapshot() Timer {
+	return self
+}
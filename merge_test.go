@@ -0,0 +1,133 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeCompleteHistograms(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	first := registry.Histogram("link1.latency", WithUnit(UnitMilliseconds))
+	defer first.Dispose()
+	second := registry.Histogram("link2.latency", WithUnit(UnitMilliseconds))
+	defer second.Dispose()
+
+	for i := int64(1); i <= 100; i++ {
+		first.Update(i)
+		second.Update(100 + i)
+	}
+
+	merged, err := MergeHistograms(first, second)
+	require.NoError(t, err)
+	require.Equal(t, UnitMilliseconds, UnitOf(merged))
+	require.Equal(t, int64(200), merged.Count())
+	require.Equal(t, int64(1), merged.Min())
+	require.Equal(t, int64(200), merged.Max())
+	require.Equal(t, int64(200*201/2), merged.Sum())
+	require.InDelta(t, 100.5, merged.Mean(), 0.0001)
+	// population variance of 1..200
+	require.InDelta(t, (200.0*200.0-1)/12, merged.Variance(), 0.0001)
+	require.InDelta(t, 100.5, merged.Percentile(0.5), 0.0001)
+}
+
+func TestMergeResamplesByCount(t *testing.T) {
+	busy := &histogramSnapshot{Histogram: metrics.NewHistogram(metrics.NewUniformSample(100))}
+	quiet := &histogramSnapshot{Histogram: metrics.NewHistogram(metrics.NewUniformSample(100))}
+	for i := 0; i < 9000; i++ {
+		busy.Update(10)
+	}
+	for i := 0; i < 1000; i++ {
+		quiet.Update(1000)
+	}
+
+	merged, err := MergeHistograms(busy, quiet)
+	require.NoError(t, err)
+	require.Equal(t, int64(10000), merged.Count())
+	require.Equal(t, 100, sampleOf(merged).Size())
+	require.Equal(t, float64(10), merged.Percentile(0.5))
+	require.Equal(t, float64(10), merged.Percentile(0.85))
+	require.Equal(t, float64(1000), merged.Percentile(0.95))
+	require.InDelta(t, 109, merged.Mean(), 0.0001)
+
+	// merged histograms can be merged again
+	again, err := MergeHistograms(merged, quiet)
+	require.NoError(t, err)
+	require.Equal(t, int64(11000), again.Count())
+	require.Equal(t, float64(1000), again.Percentile(0.9))
+}
+
+func TestMergeRequiresMatchingUnits(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	first := registry.Histogram("first", WithUnit(UnitBytes))
+	defer first.Dispose()
+	second := registry.Histogram("second", WithUnit(UnitMilliseconds))
+	defer second.Dispose()
+
+	_, err := MergeHistograms(first, second)
+	require.ErrorIs(t, err, ErrNotMergeable)
+}
+
+func TestMergeTimers(t *testing.T) {
+	for _, native := range []bool{false, true} {
+		var options []RegistryOption
+		if native {
+			options = append(options, WithNativeMeters())
+		}
+		registry := NewRegistry("test", nil, options...)
+		first := registry.Timer("first", WithRateWindows(10*time.Second, time.Minute))
+		second := registry.Timer("second", WithRateWindows(10*time.Second))
+
+		first.Update(time.Millisecond)
+		second.Update(3 * time.Millisecond)
+		second.Update(5 * time.Millisecond)
+
+		merged, err := MergeTimers(first, second)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), merged.Count())
+		require.Equal(t, int64(time.Millisecond), merged.Min())
+		require.Equal(t, int64(5*time.Millisecond), merged.Max())
+		require.Equal(t, float64(3*time.Millisecond), merged.Mean())
+		require.Equal(t, []time.Duration{10 * time.Second}, RateWindows(merged))
+		require.Greater(t, merged.RateMean(), float64(0))
+		require.Panics(t, func() { merged.Update(time.Second) })
+
+		first.Dispose()
+		second.Dispose()
+	}
+}
+
+func TestMergeUnavailableSample(t *testing.T) {
+	timer := metrics.NewTimer()
+	defer timer.Stop()
+	timer.Update(time.Second)
+
+	_, err := MergeTimers(&timerAdapter{Timer: timer})
+	require.ErrorIs(t, err, ErrNotMergeable)
+}
+
+func TestMergeEmpty(t *testing.T) {
+	merged, err := MergeHistograms(newNoopHistogram())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), merged.Count())
+	require.Equal(t, int64(0), merged.Min())
+	require.Equal(t, float64(0), merged.Percentile(0.99))
+}
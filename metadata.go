@@ -0,0 +1,110 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+// Stability indicates how much consumers can rely on a metric continuing to exist in its current form
+type Stability string
+
+const (
+	StabilityExperimental Stability = "experimental"
+	StabilityStable       Stability = "stable"
+	StabilityDeprecated   Stability = "deprecated"
+)
+
+// Metadata describes a metric, so exporters can include help text, units and type information
+type Metadata struct {
+	// Type is the kind of metric. When describing a registry it is filled in from the metric itself
+	Type MetricType
+	// Description is human-readable help text for the metric
+	Description string
+	// Unit is the unit of the metric's values. If not set, the unit the metric was created with is used
+	Unit Unit
+	// Stability indicates whether the metric is experimental, stable or deprecated
+	Stability Stability
+	// Owner identifies the component or team responsible for the metric
+	Owner string
+}
+
+// DescribeVisitor is given the metadata of each metric in a registry
+type DescribeVisitor interface {
+	VisitMetadata(name string, metadata Metadata)
+}
+
+// MetadataMetricSink may be implemented by a MetricSink which wants metric metadata, for example to emit
+// HELP and UNIT lines. If a sink implements it, the DelegatingReporter describes the registry to the sink
+// at the start of each report, after StartReport is called.
+type MetadataMetricSink interface {
+	AcceptMetadata(name string, metadata Metadata)
+}
+
+// MetricTypeOf returns the type of the given metric
+func MetricTypeOf(metric Metric) MetricType {
+	switch m := metric.(type) {
+	case Rollup:
+		return m.MetricType()
+	case Gauge:
+		return MetricTypeGauge
+	case GaugeFloat64:
+		return MetricTypeGaugeFloat64
+	case Meter:
+		return MetricTypeMeter
+	case Histogram:
+		return MetricTypeHistogram
+	case Timer:
+		return MetricTypeTimer
+	case WindowCounter:
+		return MetricTypeWindowCounter
+	case Counter:
+		return MetricTypeCounter
+	case Operation:
+		return MetricTypeOperation
+	}
+	return ""
+}
+
+func (registry *registryImpl) SetMetadata(name string, metadata Metadata) {
+	registry.metadata.Set(name, metadata)
+}
+
+func (registry *registryImpl) Metadata(name string) (Metadata, bool) {
+	metadata, found := registry.metadata.Get(name)
+	if metric, exists := registry.metricMap.Get(name); exists {
+		return describe(metric, metadata), true
+	}
+	return metadata, found
+}
+
+func (registry *registryImpl) Describe(visitor DescribeVisitor) {
+	registry.describe(visitor, "")
+}
+
+func (registry *registryImpl) describe(visitor DescribeVisitor, prefix string) {
+	registry.eachMetricWithPrefix(prefix, func(name string, metric Metric) {
+		metadata, _ := registry.metadata.Get(name)
+		visitor.VisitMetadata(name, describe(metric, metadata))
+	})
+}
+
+func describe(metric Metric, metadata Metadata) Metadata {
+	if metadata.Type == "" {
+		metadata.Type = MetricTypeOf(metric)
+	}
+	if metadata.Unit == UnitNone {
+		metadata.Unit = UnitOf(metric)
+	}
+	return metadata
+}
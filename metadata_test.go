@@ -0,0 +1,71 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type metadataSink struct {
+	*collectingSink
+	metadata map[string]Metadata
+}
+
+func (s *metadataSink) AcceptMetadata(name string, metadata Metadata) {
+	s.metadata[name] = metadata
+}
+
+func TestMetadata(t *testing.T) {
+	registry := NewRegistry("test", nil)
+
+	_, found := registry.Metadata("link.tx.bytes")
+	require.False(t, found)
+
+	registry.SetMetadata("link.tx.bytes", Metadata{
+		Description: "bytes transmitted over the link",
+		Stability:   StabilityStable,
+		Owner:       "router",
+	})
+
+	metadata, found := registry.Metadata("link.tx.bytes")
+	require.True(t, found)
+	require.Equal(t, MetricType(""), metadata.Type)
+
+	meter := registry.Meter("link.tx.bytes", WithUnit(UnitBytes))
+	metadata, found = registry.Metadata("link.tx.bytes")
+	require.True(t, found)
+	require.Equal(t, MetricTypeMeter, metadata.Type)
+	require.Equal(t, UnitBytes, metadata.Unit)
+	require.Equal(t, "bytes transmitted over the link", metadata.Description)
+
+	registry.Timer("link.latency")
+
+	sink := &metadataSink{collectingSink: newCollectingSink(), metadata: map[string]Metadata{}}
+	NewDelegatingReporter(registry, sink, nil).Report()
+	// the timer's outcome sub-timers are described too
+	require.Len(t, sink.metadata, 4)
+	require.Equal(t, StabilityStable, sink.metadata["link.tx.bytes"].Stability)
+	require.Equal(t, MetricTypeTimer, sink.metadata["link.latency"].Type)
+	require.Equal(t, UnitNanoseconds, sink.metadata["link.latency"].Unit)
+
+	meter.Dispose()
+	metadata, found = registry.Metadata("link.tx.bytes")
+	require.True(t, found)
+	require.Equal(t, "router", metadata.Owner)
+}
@@ -17,7 +17,8 @@
 package metrics
 
 import (
-	"github.com/openziti/foundation/v2/concurrenz"
+	"time"
+
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -35,14 +36,33 @@ type Meter interface {
 type meterImpl struct {
 	metrics.Meter
 	name     string
+	unit     Unit
 	registry *registryImpl
-	concurrenz.RefCount
+	refCount
+	idleTracker
+}
+
+func (self *meterImpl) Mark(n int64) {
+	self.markUpdated()
+	self.Meter.Mark(n)
 }
 
 func (self *meterImpl) Name() string {
 	return self.name
 }
 
+func (self *meterImpl) Unit() Unit {
+	return self.unit
+}
+
+func (self *meterImpl) Rate(window time.Duration) float64 {
+	return rateOf(self.Meter, window)
+}
+
+func (self *meterImpl) RateWindows() []time.Duration {
+	return rateWindowsOf(self.Meter)
+}
+
 func (self *meterImpl) Dispose() {
 	self.registry.disposeRefCounted(self)
 }
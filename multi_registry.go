@@ -0,0 +1,186 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// MultiRegistry combines a dynamic set of registries, so they can be reported together by a single
+// reporter. Metrics keep the names they have in their own registry. Visitors implementing SourceVisitor are
+// told which registry the metrics they're given come from, so they can tag them with its SourceId. Lookups
+// search the registries in the order they were added, returning the metric from the first one containing
+// the name.
+type MultiRegistry interface {
+	RegistryReader
+
+	// Add adds the given registry to the set. Registries are identified by their SourceId, so adding a
+	// registry whose source id is already used in the set returns an error wrapping ErrDuplicateSource. As
+	// child registries share the source id of their root, a child and its root can't both be added
+	Add(registry RegistryReader) error
+
+	// Remove removes the registry with the given source id from the set
+	Remove(sourceId string)
+}
+
+// SourceVisitor may be implemented by a Visitor, or a DescribeVisitor, which wants to know which registry
+// the metrics of a MultiRegistry come from. StartSource is called before the metrics of each registry are
+// visited, and EndSource after. A MetricSink implementing it is given the calls by the DelegatingReporter.
+type SourceVisitor interface {
+	StartSource(source RegistryReader)
+	EndSource(source RegistryReader)
+}
+
+// NewMultiRegistry returns a MultiRegistry over the given registries, or an error if two of them have the
+// same SourceId
+func NewMultiRegistry(sourceId string, registries ...RegistryReader) (MultiRegistry, error) {
+	result := &multiRegistryImpl{
+		sourceId: sourceId,
+	}
+	result.registries.Store(&[]RegistryReader{})
+	for _, registry := range registries {
+		if err := result.Add(registry); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type multiRegistryImpl struct {
+	sourceId string
+	// registries is replaced, never modified, when the set changes, so it can be read without a lock
+	registries atomic.Pointer[[]RegistryReader]
+	lock       sync.Mutex
+}
+
+func (self *multiRegistryImpl) Add(registry RegistryReader) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	current := *self.registries.Load()
+	for _, existing := range current {
+		if existing.SourceId() == registry.SourceId() {
+			return fmt.Errorf("%w: a registry with source id '%v' has already been added", ErrDuplicateSource, registry.SourceId())
+		}
+	}
+
+	next := make([]RegistryReader, 0, len(current)+1)
+	next = append(next, current...)
+	next = append(next, registry)
+	self.registries.Store(&next)
+	return nil
+}
+
+func (self *multiRegistryImpl) Remove(sourceId string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	current := *self.registries.Load()
+	next := make([]RegistryReader, 0, len(current))
+	for _, registry := range current {
+		if registry.SourceId() != sourceId {
+			next = append(next, registry)
+		}
+	}
+	self.registries.Store(&next)
+}
+
+func (self *multiRegistryImpl) SourceId() string {
+	return self.sourceId
+}
+
+func getFromMulti[T Metric](self *multiRegistryImpl, name string, get func(registry RegistryReader, name string) T) T {
+	for _, registry := range *self.registries.Load() {
+		if registry.IsValidMetric(name) {
+			return get(registry, name)
+		}
+	}
+	var result T
+	return result
+}
+
+func (self *multiRegistryImpl) EachMetric(visitor func(name string, metric Metric)) {
+	for _, registry := range *self.registries.Load() {
+		registry.EachMetric(visitor)
+	}
+}
+
+func (self *multiRegistryImpl) GetGauge(name string) Gauge {
+	return getFromMulti(self, name, RegistryReader.GetGauge)
+}
+
+func (self *multiRegistryImpl) GetGaugeFloat64(name string) GaugeFloat64 {
+	return getFromMulti(self, name, RegistryReader.GetGaugeFloat64)
+}
+
+func (self *multiRegistryImpl) GetMeter(name string) Meter {
+	return getFromMulti(self, name, RegistryReader.GetMeter)
+}
+
+func (self *multiRegistryImpl) GetHistogram(name string) Histogram {
+	return getFromMulti(self, name, RegistryReader.GetHistogram)
+}
+
+func (self *multiRegistryImpl) GetTimer(name string) Timer {
+	return getFromMulti(self, name, RegistryReader.GetTimer)
+}
+
+func (self *multiRegistryImpl) IsValidMetric(name string) bool {
+	for _, registry := range *self.registries.Load() {
+		if registry.IsValidMetric(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *multiRegistryImpl) AcceptVisitor(visitor Visitor) {
+	sourceVisitor, _ := visitor.(SourceVisitor)
+	for _, registry := range *self.registries.Load() {
+		if sourceVisitor != nil {
+			sourceVisitor.StartSource(registry)
+		}
+		registry.AcceptVisitor(visitor)
+		if sourceVisitor != nil {
+			sourceVisitor.EndSource(registry)
+		}
+	}
+}
+
+func (self *multiRegistryImpl) Metadata(name string) (Metadata, bool) {
+	for _, registry := range *self.registries.Load() {
+		if result, found := registry.Metadata(name); found {
+			return result, true
+		}
+	}
+	return Metadata{}, false
+}
+
+func (self *multiRegistryImpl) Describe(visitor DescribeVisitor) {
+	sourceVisitor, _ := visitor.(SourceVisitor)
+	for _, registry := range *self.registries.Load() {
+		if sourceVisitor != nil {
+			sourceVisitor.StartSource(registry)
+		}
+		registry.Describe(visitor)
+		if sourceVisitor != nil {
+			sourceVisitor.EndSource(registry)
+		}
+	}
+}
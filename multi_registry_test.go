@@ -0,0 +1,133 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiRegistry(t *testing.T) {
+	controller := NewRegistry("controller", nil)
+	router := NewRegistry("router", nil)
+	controller.Meter("api.requests").Mark(2)
+	router.Meter("xgress.bytes").Mark(5)
+	router.Gauge("links").Update(3)
+
+	multi, err := NewMultiRegistry("process", controller, router)
+	require.NoError(t, err)
+	require.Equal(t, "process", multi.SourceId())
+	require.Equal(t, int64(2), multi.GetMeter("api.requests").Count())
+	require.Equal(t, int64(3), multi.GetGauge("links").Value())
+	require.Nil(t, multi.GetMeter("links"))
+	require.Nil(t, multi.GetTimer("missing"))
+	require.True(t, multi.IsValidMetric("xgress.bytes"))
+
+	sink := report(multi)
+	require.Equal(t, int64(2), sink.ints["api.requests.count"])
+	require.Equal(t, int64(5), sink.ints["xgress.bytes.count"])
+	require.Equal(t, int64(3), sink.ints["links"])
+
+	multi.Remove("router")
+	require.False(t, multi.IsValidMetric("links"))
+	sink = report(multi)
+	require.NotContains(t, sink.ints, "links")
+}
+
+func TestMultiRegistrySources(t *testing.T) {
+	controller := NewRegistry("controller", nil)
+	router := NewRegistry("router", map[string]string{"host": "a"})
+	controller.Gauge("sessions").Update(1)
+	router.Gauge("sessions").Update(2)
+
+	multi, err := NewMultiRegistry("process", controller)
+	require.NoError(t, err)
+	require.NoError(t, multi.Add(router))
+
+	// lookups search the registries in the order they were added
+	require.Equal(t, int64(1), multi.GetGauge("sessions").Value())
+	metadata, found := multi.Metadata("sessions")
+	require.True(t, found)
+	require.Equal(t, MetricTypeGauge, metadata.Type)
+
+	// sinks which are told the source of each metric can tag it
+	sink := &sourceSink{collectingSink: newCollectingSink(), values: map[string]int64{}}
+	NewDelegatingReporter(multi, sink, nil).Report()
+	require.Equal(t, map[string]int64{"controller/sessions": 1, "router/sessions": 2}, sink.values)
+
+	// sinks which only take a Registry are given a report for each source
+	legacy := &legacySink{collectingSink: newCollectingSink()}
+	NewDelegatingReporter(multi, legacy, nil).Report()
+	require.Equal(t, []Registry{controller, controller, router, router}, legacy.registries)
+
+	// registries are identified by their source id, which children share with their root
+	require.ErrorIs(t, multi.Add(NewRegistry("router", nil)), ErrDuplicateSource)
+	require.ErrorIs(t, multi.Add(controller.Child("child", nil)), ErrDuplicateSource)
+	_, err = NewMultiRegistry("process", router, router)
+	require.ErrorIs(t, err, ErrDuplicateSource)
+
+	multi.Remove("controller")
+	require.Equal(t, int64(2), multi.GetGauge("sessions").Value())
+}
+
+// sourceSink records the values of gauges under the source id of the registry they come from
+type sourceSink struct {
+	*collectingSink
+	source RegistryReader
+	values map[string]int64
+}
+
+func (s *sourceSink) StartSource(source RegistryReader) { s.source = source }
+func (s *sourceSink) EndSource(RegistryReader)          { s.source = nil }
+func (s *sourceSink) AcceptIntMetric(name string, value int64) {
+	s.values[s.source.SourceId()+"/"+name] = value
+}
+
+// legacySink records the registries given to StartReport and EndReport
+type legacySink struct {
+	*collectingSink
+	registries []Registry
+}
+
+func (s *legacySink) StartReport(registry Registry) { s.registries = append(s.registries, registry) }
+func (s *legacySink) EndReport(registry Registry)   { s.registries = append(s.registries, registry) }
+
+type readerSink struct {
+	*collectingSink
+	registries []RegistryReader
+}
+
+func (s *readerSink) StartReaderReport(registry RegistryReader) {
+	s.registries = append(s.registries, registry)
+}
+
+func (s *readerSink) EndReaderReport(registry RegistryReader) {
+	s.registries = append(s.registries, registry)
+}
+
+func TestMultiRegistryReaderSink(t *testing.T) {
+	router := NewRegistry("router", nil)
+	router.Gauge("links").Update(3)
+	multi, err := NewMultiRegistry("process", router)
+	require.NoError(t, err)
+
+	sink := &readerSink{collectingSink: newCollectingSink()}
+	NewDelegatingReporter(multi, sink, nil).Report()
+	require.Equal(t, []RegistryReader{multi, multi}, sink.registries)
+	require.Equal(t, int64(3), sink.ints["links"])
+}
@@ -0,0 +1,108 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// nameIndex holds the names of the metrics in a registry as a tree of their '.' separated segments, so the
+// metrics under a prefix, such as those of a child registry, can be found without scanning the registry
+type nameIndex struct {
+	lock sync.Mutex
+	root nameNode
+}
+
+type nameNode struct {
+	// name is the full name the node stands for
+	name string
+	// count is the number of times the name has been added, less the number of times it's been removed.
+	// Adds and removes of the same name may be applied out of order, so it can briefly be negative
+	count    int
+	children map[string]*nameNode
+}
+
+func (self *nameIndex) add(name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.root.update(name, 0, 1)
+}
+
+func (self *nameIndex) remove(name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.root.update(name, 0, -1)
+}
+
+// update adjusts the count of the named descendant, whose segments below this node start at the given
+// offset, pruning nodes which no longer hold any names
+func (self *nameNode) update(name string, offset int, delta int) {
+	end := strings.IndexByte(name[offset:], '.')
+	more := end >= 0
+	if more {
+		end += offset
+	} else {
+		end = len(name)
+	}
+
+	segment := name[offset:end]
+	child, found := self.children[segment]
+	if !found {
+		if self.children == nil {
+			self.children = map[string]*nameNode{}
+		}
+		child = &nameNode{name: name[:end]}
+		self.children[segment] = child
+	}
+
+	if more {
+		child.update(name, end+1, delta)
+	} else {
+		child.count += delta
+	}
+
+	if child.count == 0 && len(child.children) == 0 {
+		delete(self.children, segment)
+	}
+}
+
+// eachName calls f with each name starting with the given prefix, which must be empty or end with a '.'.
+// The index is locked while f is called, so the names are a consistent snapshot
+func (self *nameIndex) eachName(prefix string, f func(name string)) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	node := &self.root
+	if prefix != "" {
+		for _, segment := range strings.Split(strings.TrimSuffix(prefix, "."), ".") {
+			if node = node.children[segment]; node == nil {
+				return
+			}
+		}
+	}
+	node.eachName(f)
+}
+
+func (self *nameNode) eachName(f func(name string)) {
+	for _, child := range self.children {
+		if child.count > 0 {
+			f(child.name)
+		}
+		child.eachName(f)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func namesWithPrefix(index *nameIndex, prefix string) []string {
+	var names []string
+	index.eachName(prefix, func(name string) {
+		names = append(names, name)
+	})
+	return names
+}
+
+func TestNameIndex(t *testing.T) {
+	index := &nameIndex{}
+	index.add("link")
+	index.add("link.l1.tx")
+	index.add("link.l1.circuit.c1")
+	index.add("link.l10.tx")
+	index.add("other")
+
+	require.ElementsMatch(t, []string{"link.l1.tx", "link.l1.circuit.c1"}, namesWithPrefix(index, "link.l1."))
+	require.ElementsMatch(t, []string{"link.l1.tx", "link.l1.circuit.c1", "link.l10.tx"}, namesWithPrefix(index, "link."))
+	require.Len(t, namesWithPrefix(index, ""), 5)
+	require.Empty(t, namesWithPrefix(index, "missing."))
+
+	// a remove which is applied before its add cancels it out
+	index.remove("link.l2.tx")
+	require.Empty(t, namesWithPrefix(index, "link.l2."))
+	index.add("link.l2.tx")
+	require.Empty(t, namesWithPrefix(index, "link.l2."))
+
+	// removing names prunes the nodes which held them
+	for _, name := range []string{"link", "link.l1.tx", "link.l1.circuit.c1", "link.l10.tx", "other"} {
+		index.remove(name)
+	}
+	require.Empty(t, index.root.children)
+}
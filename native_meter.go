@@ -0,0 +1,350 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// meterTickInterval is how often EWMA rates are updated, matching go-metrics
+	meterTickInterval = 5 * time.Second
+
+	// minMeterTickInterval bounds how often rates are updated for meters with short custom windows
+	minMeterTickInterval = 100 * time.Millisecond
+)
+
+// WithNativeMeters makes the registry create meters which don't depend on the go-metrics arbiter. go-metrics
+// meters are ticked every five seconds by a global goroutine, which locks across all meters and so gets
+// expensive with many meters. Native meters instead update their rates lazily, when they're read. Rates
+// are the same as those of go-metrics meters if meters are read at least every five seconds. Otherwise,
+// events since the last read are assumed to have been spread evenly over the time since. Timers created
+// by the registry use native meters as well.
+func WithNativeMeters() RegistryOption {
+	return func(registry *registryImpl) {
+		registry.nativeMeters = true
+	}
+}
+
+// processStart anchors nanotime, so it reads the monotonic clock rather than the wall clock, which may
+// jump when the system time is changed
+var processStart = time.Now()
+
+// nanotime returns the nanoseconds elapsed since the process started, according to the monotonic clock
+func nanotime() int64 {
+	return int64(time.Since(processStart))
+}
+
+func newNativeMeter(windows ...time.Duration) *nativeMeter {
+	return newNativeMeterWithClock(nanotime, windows...)
+}
+
+// newShardedNativeMeter returns a native meter which counts events using a striped counter, for meters
+// marked from many goroutines at once
+func newShardedNativeMeter(windows ...time.Duration) *nativeMeter {
+	result := newNativeMeter(windows...)
+	result.striped = newStripedCounter()
+	return result
+}
+
+func newNativeMeterWithClock(clock func() int64, windows ...time.Duration) *nativeMeter {
+	// update rates often enough that the shortest window sees ten updates
+	tickInterval := meterTickInterval
+	for _, window := range windows {
+		tickInterval = min(tickInterval, max(window/10, minMeterTickInterval))
+	}
+
+	now := clock()
+	result := &nativeMeter{
+		clock:        clock,
+		startTime:    now,
+		tickInterval: tickInterval,
+		rate1:        newLazyEWMA(time.Minute, tickInterval),
+		rate5:        newLazyEWMA(5*time.Minute, tickInterval),
+		rate15:       newLazyEWMA(15*time.Minute, tickInterval),
+	}
+	for _, window := range windows {
+		if result.rate(window) == nil {
+			result.windows = append(result.windows, windowedEWMA{
+				window: window,
+				ewma:   newLazyEWMA(window, tickInterval),
+			})
+		}
+	}
+	result.lastTick.Store(now)
+	return result
+}
+
+// nativeMeter is a metrics.Meter which is lock-free and doesn't need a goroutine to tick it. Marking only
+// increments the count. The rates are brought up to date when they're read, from the events counted and
+// the time elapsed since they were last updated.
+type nativeMeter struct {
+	count        atomic.Int64
+	striped      *stripedCounter
+	lastTick     atomic.Int64
+	ticking      atomic.Bool
+	ticked       int64
+	startTime    int64
+	tickInterval time.Duration
+	clock        func() int64
+
+	rate1   *lazyEWMA
+	rate5   *lazyEWMA
+	rate15  *lazyEWMA
+	windows []windowedEWMA
+}
+
+type windowedEWMA struct {
+	window time.Duration
+	ewma   *lazyEWMA
+}
+
+func (self *nativeMeter) Count() int64 {
+	if self.striped != nil {
+		return self.striped.Load()
+	}
+	return self.count.Load()
+}
+
+func (self *nativeMeter) Mark(n int64) {
+	if self.striped != nil {
+		self.striped.Add(n)
+	} else {
+		self.count.Add(n)
+	}
+}
+
+func (self *nativeMeter) Rate1() float64 {
+	self.tickIfNeeded()
+	return self.rate1.Rate()
+}
+
+func (self *nativeMeter) Rate5() float64 {
+	self.tickIfNeeded()
+	return self.rate5.Rate()
+}
+
+func (self *nativeMeter) Rate15() float64 {
+	self.tickIfNeeded()
+	return self.rate15.Rate()
+}
+
+// Rate returns the rate over the given window, which must be one of the standard windows or one of the
+// windows the meter was created with. Returns 0 for other windows
+func (self *nativeMeter) Rate(window time.Duration) float64 {
+	self.tickIfNeeded()
+	if ewma := self.rate(window); ewma != nil {
+		return ewma.Rate()
+	}
+	return 0
+}
+
+func (self *nativeMeter) rate(window time.Duration) *lazyEWMA {
+	switch window {
+	case time.Minute:
+		return self.rate1
+	case 5 * time.Minute:
+		return self.rate5
+	case 15 * time.Minute:
+		return self.rate15
+	}
+	for _, windowed := range self.windows {
+		if windowed.window == window {
+			return windowed.ewma
+		}
+	}
+	return nil
+}
+
+// RateWindows returns the custom windows the meter was created with
+func (self *nativeMeter) RateWindows() []time.Duration {
+	var result []time.Duration
+	for _, windowed := range self.windows {
+		result = append(result, windowed.window)
+	}
+	return result
+}
+
+func (self *nativeMeter) RateMean() float64 {
+	return self.rateMean(self.Count(), self.clock())
+}
+
+func (self *nativeMeter) rateMean(count int64, now int64) float64 {
+	elapsed := time.Duration(now - self.startTime).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(count) / elapsed
+}
+
+func (self *nativeMeter) Snapshot() metrics.Meter {
+	self.tickIfNeeded()
+	return self.snapshot()
+}
+
+func (self *nativeMeter) snapshot() *nativeMeterSnapshot {
+	count := self.Count()
+	result := &nativeMeterSnapshot{
+		count:    count,
+		rate1:    self.rate1.Rate(),
+		rate5:    self.rate5.Rate(),
+		rate15:   self.rate15.Rate(),
+		rateMean: self.rateMean(count, self.clock()),
+	}
+	for _, windowed := range self.windows {
+		result.windows = append(result.windows, windowedRate{
+			window: windowed.window,
+			rate:   windowed.ewma.Rate(),
+		})
+	}
+	return result
+}
+
+// Stop is a no-op, as native meters don't hold any resources
+func (self *nativeMeter) Stop() {}
+
+// tickIfNeeded applies the ticks which have elapsed since the rates were last updated. If another
+// goroutine is already updating the rates, it returns without waiting and the current rates are used
+func (self *nativeMeter) tickIfNeeded() {
+	now := self.clock()
+	if time.Duration(now-self.lastTick.Load()) < self.tickInterval {
+		return
+	}
+
+	if !self.ticking.CompareAndSwap(false, true) {
+		return
+	}
+	defer self.ticking.Store(false)
+
+	lastTick := self.lastTick.Load()
+	ticks := int64(time.Duration(now-lastTick) / self.tickInterval)
+	if ticks < 1 {
+		return
+	}
+
+	count := self.Count()
+	instantRate := float64(count-self.ticked) / (float64(ticks) * self.tickInterval.Seconds())
+	self.ticked = count
+
+	self.tick(instantRate, ticks)
+	self.lastTick.Store(lastTick + ticks*int64(self.tickInterval))
+}
+
+func (self *nativeMeter) tick(instantRate float64, ticks int64) {
+	self.rate1.tick(instantRate, ticks)
+	self.rate5.tick(instantRate, ticks)
+	self.rate15.tick(instantRate, ticks)
+	for _, windowed := range self.windows {
+		windowed.ewma.tick(instantRate, ticks)
+	}
+}
+
+func newLazyEWMA(window time.Duration, tickInterval time.Duration) *lazyEWMA {
+	return &lazyEWMA{
+		alpha: 1 - math.Exp(-tickInterval.Seconds()/window.Seconds()),
+	}
+}
+
+// lazyEWMA is an exponentially weighted moving average of a rate, per second, which can apply several
+// ticks at once. Ticks must not be applied concurrently.
+type lazyEWMA struct {
+	alpha       float64
+	rate        atomic.Uint64
+	initialized bool
+}
+
+func (self *lazyEWMA) Rate() float64 {
+	return math.Float64frombits(self.rate.Load())
+}
+
+// tick applies the given number of ticks, with the given instant rate for each of them
+func (self *lazyEWMA) tick(instantRate float64, ticks int64) {
+	if !self.initialized {
+		// like go-metrics, the first tick sets the rate, and later ticks at the same rate don't change it
+		self.initialized = true
+		self.rate.Store(math.Float64bits(instantRate))
+		return
+	}
+	decay := math.Pow(1-self.alpha, float64(ticks))
+	rate := instantRate + (self.Rate()-instantRate)*decay
+	self.rate.Store(math.Float64bits(rate))
+}
+
+type nativeMeterSnapshot struct {
+	count    int64
+	rate1    float64
+	rate5    float64
+	rate15   float64
+	rateMean float64
+	windows  []windowedRate
+}
+
+type windowedRate struct {
+	window time.Duration
+	rate   float64
+}
+
+func (self *nativeMeterSnapshot) Count() int64 {
+	return self.count
+}
+
+func (self *nativeMeterSnapshot) Mark(int64) {
+	panic("Mark called on a meter snapshot")
+}
+
+func (self *nativeMeterSnapshot) Rate1() float64 {
+	return self.rate1
+}
+
+func (self *nativeMeterSnapshot) Rate5() float64 {
+	return self.rate5
+}
+
+func (self *nativeMeterSnapshot) Rate15() float64 {
+	return self.rate15
+}
+
+func (self *nativeMeterSnapshot) RateMean() float64 {
+	return self.rateMean
+}
+
+func (self *nativeMeterSnapshot) Rate(window time.Duration) float64 {
+	for _, windowed := range self.windows {
+		if windowed.window == window {
+			return windowed.rate
+		}
+	}
+	return standardRate(self, window)
+}
+
+func (self *nativeMeterSnapshot) RateWindows() []time.Duration {
+	var result []time.Duration
+	for _, windowed := range self.windows {
+		result = append(result, windowed.window)
+	}
+	return result
+}
+
+func (self *nativeMeterSnapshot) Snapshot() metrics.Meter {
+	return self
+}
+
+func (self *nativeMeterSnapshot) Stop() {}
@@ -0,0 +1,204 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now int64
+}
+
+func (self *fakeClock) nanotime() int64 {
+	return self.now
+}
+
+func (self *fakeClock) advance(d time.Duration) {
+	self.now += int64(d)
+}
+
+type referenceRates struct {
+	rate1, rate5, rate15 metrics.EWMA
+}
+
+func newReferenceRates() *referenceRates {
+	return &referenceRates{
+		rate1:  metrics.NewEWMA1(),
+		rate5:  metrics.NewEWMA5(),
+		rate15: metrics.NewEWMA15(),
+	}
+}
+
+func (self *referenceRates) mark(n int64) {
+	self.rate1.Update(n)
+	self.rate5.Update(n)
+	self.rate15.Update(n)
+}
+
+func (self *referenceRates) tick() {
+	self.rate1.Tick()
+	self.rate5.Tick()
+	self.rate15.Tick()
+}
+
+func (self *referenceRates) requireMatches(t *testing.T, meter metrics.Meter) {
+	t.Helper()
+	require.InDelta(t, self.rate1.Rate(), meter.Rate1(), 1e-9)
+	require.InDelta(t, self.rate5.Rate(), meter.Rate5(), 1e-9)
+	require.InDelta(t, self.rate15.Rate(), meter.Rate15(), 1e-9)
+}
+
+func TestNativeMeterMatchesGoMetrics(t *testing.T) {
+	clock := &fakeClock{now: time.Now().UnixNano()}
+	meter := newNativeMeterWithClock(clock.nanotime)
+	reference := newReferenceRates()
+
+	for i := int64(0); i < 40; i++ {
+		n := (i % 7) * 100
+		meter.Mark(n)
+		reference.mark(n)
+		clock.advance(meterTickInterval)
+		reference.tick()
+		reference.requireMatches(t, meter)
+	}
+
+	require.InDelta(t, float64(meter.Count())/(40*meterTickInterval.Seconds()), meter.RateMean(), 1e-9)
+}
+
+func TestNativeMeterLazyTicks(t *testing.T) {
+	clock := &fakeClock{now: time.Now().UnixNano()}
+	meter := newNativeMeterWithClock(clock.nanotime)
+	reference := newReferenceRates()
+
+	meter.Mark(500)
+	reference.mark(500)
+	clock.advance(meterTickInterval)
+	reference.tick()
+	reference.requireMatches(t, meter)
+
+	// evenly spread events which aren't read until several ticks later
+	for i := 0; i < 12; i++ {
+		meter.Mark(100)
+		reference.mark(100)
+		clock.advance(meterTickInterval)
+		reference.tick()
+	}
+	reference.requireMatches(t, meter)
+
+	// idle time decays the rates
+	clock.advance(10 * time.Minute)
+	for i := 0; i < 120; i++ {
+		reference.tick()
+	}
+	reference.requireMatches(t, meter)
+
+	snapshot := meter.Snapshot()
+	require.Equal(t, int64(1700), snapshot.Count())
+	require.Equal(t, meter.Rate1(), snapshot.Rate1())
+	require.Panics(t, func() { snapshot.Mark(1) })
+}
+
+func TestRegistryNativeMeters(t *testing.T) {
+	registry := NewRegistry("test", nil, WithNativeMeters())
+	meter := registry.Meter("meter")
+	meter.Mark(3)
+	require.IsType(t, &nativeMeter{}, meter.(*meterImpl).Meter)
+
+	sink := report(registry)
+	require.Equal(t, int64(3), sink.ints["meter.count"])
+	meter.Dispose()
+}
+
+func BenchmarkMeterMark(b *testing.B) {
+	meters := map[string]func() metrics.Meter{
+		"go-metrics": metrics.NewMeter,
+		"native":     func() metrics.Meter { return newNativeMeter() },
+	}
+	for name, newMeter := range meters {
+		b.Run(name, func(b *testing.B) {
+			meter := newMeter()
+			defer meter.Stop()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				meter.Mark(1)
+			}
+		})
+
+		b.Run(name+"-parallel", func(b *testing.B) {
+			meter := newMeter()
+			defer meter.Stop()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					meter.Mark(1)
+				}
+			})
+		})
+
+		b.Run(name+"-read", func(b *testing.B) {
+			meter := newMeter()
+			defer meter.Stop()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				meter.Mark(1)
+				_ = meter.Rate1()
+			}
+		})
+	}
+}
+
+// BenchmarkManyMeters measures marking across many meters, which for go-metrics meters includes contention
+// with the arbiter, which ticks every meter while holding a lock
+func BenchmarkManyMeters(b *testing.B) {
+	meters := map[string]func() metrics.Meter{
+		"go-metrics": metrics.NewMeter,
+		"native":     func() metrics.Meter { return newNativeMeter() },
+	}
+	for name, newMeter := range meters {
+		for _, count := range []int{1000, 50000} {
+			b.Run(fmt.Sprintf("%v-%v", name, count), func(b *testing.B) {
+				all := make([]metrics.Meter, count)
+				for i := range all {
+					all[i] = newMeter()
+				}
+				defer func() {
+					for _, meter := range all {
+						meter.Stop()
+					}
+				}()
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						all[i%count].Mark(1)
+						i++
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// newNativeTimer returns a metrics.Timer which records durations in the same kind of histogram as
+// metrics.NewTimer, but rates using a native meter. go-metrics timers can't be built from a custom meter,
+// as their snapshots require go-metrics meter snapshots.
+func newNativeTimer(windows ...time.Duration) *nativeTimer {
+	return newNativeTimerWithMeter(newNativeMeter(windows...))
+}
+
+// newNativeTimerWithMeter returns a timer like newNativeTimer, which records rates using the given meter.
+// Registries use it with a go-metrics meter when native meters aren't enabled, so timer snapshots always
+// expose their histogram, which go-metrics timer snapshots don't, and can be merged
+func newNativeTimerWithMeter(meter metrics.Meter) *nativeTimer {
+	return &nativeTimer{
+		histogram: metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015)),
+		meter:     meter,
+	}
+}
+
+type nativeTimer struct {
+	histogram metrics.Histogram
+	meter     metrics.Meter
+}
+
+func (self *nativeTimer) Count() int64 {
+	return self.histogram.Count()
+}
+
+func (self *nativeTimer) Max() int64 {
+	return self.histogram.Max()
+}
+
+func (self *nativeTimer) Mean() float64 {
+	return self.histogram.Mean()
+}
+
+func (self *nativeTimer) Min() int64 {
+	return self.histogram.Min()
+}
+
+func (self *nativeTimer) Percentile(p float64) float64 {
+	return self.histogram.Percentile(p)
+}
+
+func (self *nativeTimer) Percentiles(ps []float64) []float64 {
+	return self.histogram.Percentiles(ps)
+}
+
+func (self *nativeTimer) Rate1() float64 {
+	return self.meter.Rate1()
+}
+
+func (self *nativeTimer) Rate5() float64 {
+	return self.meter.Rate5()
+}
+
+func (self *nativeTimer) Rate15() float64 {
+	return self.meter.Rate15()
+}
+
+func (self *nativeTimer) RateMean() float64 {
+	return self.meter.RateMean()
+}
+
+func (self *nativeTimer) Rate(window time.Duration) float64 {
+	return rateOf(self.meter, window)
+}
+
+func (self *nativeTimer) RateWindows() []time.Duration {
+	return rateWindowsOf(self.meter)
+}
+
+func (self *nativeTimer) Snapshot() metrics.Timer {
+	return &nativeTimerSnapshot{
+		histogram: self.histogram.Snapshot(),
+		meter:     self.meter.Snapshot(),
+	}
+}
+
+// Sample returns the sample of the histogram recording the durations
+func (self *nativeTimer) Sample() metrics.Sample {
+	return self.histogram.Sample()
+}
+
+func (self *nativeTimer) StdDev() float64 {
+	return self.histogram.StdDev()
+}
+
+func (self *nativeTimer) Stop() {
+	self.meter.Stop()
+}
+
+func (self *nativeTimer) Sum() int64 {
+	return self.histogram.Sum()
+}
+
+func (self *nativeTimer) Time(f func()) {
+	start := time.Now()
+	f()
+	self.UpdateSince(start)
+}
+
+func (self *nativeTimer) Update(d time.Duration) {
+	self.histogram.Update(int64(d))
+	self.meter.Mark(1)
+}
+
+func (self *nativeTimer) UpdateSince(ts time.Time) {
+	self.Update(time.Since(ts))
+}
+
+func (self *nativeTimer) Variance() float64 {
+	return self.histogram.Variance()
+}
+
+type nativeTimerSnapshot struct {
+	histogram metrics.Histogram
+	meter     metrics.Meter
+}
+
+func (self *nativeTimerSnapshot) Count() int64 {
+	return self.histogram.Count()
+}
+
+func (self *nativeTimerSnapshot) Max() int64 {
+	return self.histogram.Max()
+}
+
+func (self *nativeTimerSnapshot) Mean() float64 {
+	return self.histogram.Mean()
+}
+
+func (self *nativeTimerSnapshot) Min() int64 {
+	return self.histogram.Min()
+}
+
+func (self *nativeTimerSnapshot) Percentile(p float64) float64 {
+	return self.histogram.Percentile(p)
+}
+
+func (self *nativeTimerSnapshot) Percentiles(ps []float64) []float64 {
+	return self.histogram.Percentiles(ps)
+}
+
+func (self *nativeTimerSnapshot) Rate1() float64 {
+	return self.meter.Rate1()
+}
+
+func (self *nativeTimerSnapshot) Rate5() float64 {
+	return self.meter.Rate5()
+}
+
+func (self *nativeTimerSnapshot) Rate15() float64 {
+	return self.meter.Rate15()
+}
+
+func (self *nativeTimerSnapshot) RateMean() float64 {
+	return self.meter.RateMean()
+}
+
+func (self *nativeTimerSnapshot) Rate(window time.Duration) float64 {
+	return rateOf(self.meter, window)
+}
+
+func (self *nativeTimerSnapshot) RateWindows() []time.Duration {
+	return rateWindowsOf(self.meter)
+}
+
+func (self *nativeTimerSnapshot) Snapshot() metrics.Timer {
+	return self
+}
+
+func (self *nativeTimerSnapshot) Sample() metrics.Sample {
+	return self.histogram.Sample()
+}
+
+func (self *nativeTimerSnapshot) StdDev() float64 {
+	return self.histogram.StdDev()
+}
+
+func (self *nativeTimerSnapshot) Stop() {}
+
+func (self *nativeTimerSnapshot) Sum() int64 {
+	return self.histogram.Sum()
+}
+
+func (self *nativeTimerSnapshot) Time(func()) {
+	panic("Time called on a timer snapshot")
+}
+
+func (self *nativeTimerSnapshot) Update(time.Duration) {
+	panic("Update called on a timer snapshot")
+}
+
+func (self *nativeTimerSnapshot) UpdateSince(time.Time) {
+	panic("UpdateSince called on a timer snapshot")
+}
+
+func (self *nativeTimerSnapshot) Variance() float64 {
+	return self.histogram.Variance()
+}
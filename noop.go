@@ -0,0 +1,87 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// The no-op metrics are handed out by registries using ErrorPolicyLogAndNoop when a metric can't be
+// created. They aren't stored in the registry, so they are never reported.
+
+type noopGauge struct {
+	metrics.NilGauge
+}
+
+func newNoopGauge() Gauge {
+	return noopGauge{}
+}
+
+func (noopGauge) Unit() Unit { return UnitNone }
+func (noopGauge) Dispose()   {}
+
+type noopGaugeFloat64 struct {
+	metrics.NilGaugeFloat64
+}
+
+func newNoopGaugeFloat64() GaugeFloat64 {
+	return noopGaugeFloat64{}
+}
+
+func (noopGaugeFloat64) Unit() Unit { return UnitNone }
+func (noopGaugeFloat64) Dispose()   {}
+
+type noopMeter struct {
+	metrics.NilMeter
+}
+
+func newNoopMeter() Meter {
+	return noopMeter{}
+}
+
+func (noopMeter) Unit() Unit                   { return UnitNone }
+func (noopMeter) Dispose()                     {}
+func (noopMeter) Rate(time.Duration) float64   { return 0 }
+func (noopMeter) RateWindows() []time.Duration { return nil }
+
+type noopHistogram struct {
+	metrics.NilHistogram
+}
+
+func newNoopHistogram() Histogram {
+	return noopHistogram{}
+}
+
+func (noopHistogram) Unit() Unit                     { return UnitNone }
+func (noopHistogram) Dispose()                       {}
+func (self noopHistogram) CreateSnapshot() Histogram { return self }
+
+type noopTimer struct {
+	metrics.NilTimer
+}
+
+func newNoopTimer() Timer {
+	return noopTimer{}
+}
+
+func (noopTimer) Unit() Unit                   { return UnitNanoseconds }
+func (noopTimer) Dispose()                     {}
+func (noopTimer) Rate(time.Duration) float64   { return 0 }
+func (noopTimer) RateWindows() []time.Duration { return nil }
+func (self noopTimer) CreateSnapshot() Timer   { return self }
@@ -0,0 +1,314 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Standard outcomes of an Operation. Other outcomes, such as RPC status codes, can be recorded using
+// Operation.Record or by returning an error implementing OutcomeProvider
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+	// OutcomeOther counts the invocations with outcomes recorded after an operation's outcome set is full
+	OutcomeOther = "other"
+)
+
+// maxOperationOutcomes is the number of distinct outcomes an operation keeps a meter for, including the
+// standard outcomes. Outcomes recorded once the limit is reached are counted under OutcomeOther
+const maxOperationOutcomes = 32
+
+// Operation times invocations of an operation, such as an RPC handler, and counts them by outcome. It's
+// made up of a timer, recording the duration of every invocation, and a meter per outcome. The standard
+// outcomes always have a meter; meters for other outcomes are created the first time they're recorded, so
+// outcomes should come from a small, fixed set. Once an operation has meters for 32 outcomes, outcomes it
+// hasn't seen before are counted under OutcomeOther. An operation is stored in the registry under a single
+// name, and visited as a unit, see OperationVisitor.
+type Operation interface {
+	Metric
+	// Start returns an OperationStopwatch which records an invocation once it's done
+	Start() OperationStopwatch
+	// Time times the given function, recording its outcome as given by OutcomeOf, and returns its error
+	Time(f func() error) error
+	// Record records an invocation with the given outcome and duration
+	Record(outcome string, duration time.Duration)
+	// Timer returns a snapshot of the timer recording the durations of all invocations
+	Timer() Timer
+	// Outcome returns the meter counting invocations with the given outcome, or nil if it has never been recorded
+	Outcome(outcome string) Meter
+	// Outcomes returns the outcomes which have a meter, in order. The returned slice must not be modified
+	Outcomes() []string
+}
+
+// OutcomeProvider may be implemented by errors which carry their own outcome, such as an RPC status code
+type OutcomeProvider interface {
+	error
+	Outcome() string
+}
+
+// OutcomeOf classifies the result of an invocation. A nil error is a success. Errors implementing
+// OutcomeProvider give their own outcome. Deadline errors, and errors with a Timeout method returning true,
+// are timeouts. Any other error is an OutcomeError
+func OutcomeOf(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+
+	var outcomeErr OutcomeProvider
+	if errors.As(err, &outcomeErr) {
+		return outcomeErr.Outcome()
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return OutcomeTimeout
+	}
+
+	var timeoutErr interface{ Timeout() bool }
+	if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
+		return OutcomeTimeout
+	}
+
+	return OutcomeError
+}
+
+// OperationStopwatch measures a single invocation of an Operation. A typical use is
+//
+//	stopwatch := operation.Start()
+//	result, err := handle(request)
+//	stopwatch.DoneErr(err)
+type OperationStopwatch struct {
+	operation Operation
+	start     time.Time
+}
+
+// Done records the invocation with the given outcome, returning its duration
+func (self OperationStopwatch) Done(outcome string) time.Duration {
+	elapsed := time.Since(self.start)
+	self.operation.Record(outcome, elapsed)
+	return elapsed
+}
+
+// DoneErr records the invocation with the outcome given by OutcomeOf for the given error, returning its duration
+func (self OperationStopwatch) DoneErr(err error) time.Duration {
+	return self.Done(OutcomeOf(err))
+}
+
+// OperationVisitor may be implemented by a Visitor which handles operations natively. Visitors which
+// don't implement it are given an operation's timer under the operation's name, and the meter of each
+// outcome named <name>.<outcome>
+type OperationVisitor interface {
+	VisitOperation(name string, operation Operation)
+}
+
+func visitOperation(name string, operation Operation, visitor Visitor) {
+	if operationVisitor, ok := visitor.(OperationVisitor); ok {
+		operationVisitor.VisitOperation(name, operation)
+		return
+	}
+	visitor.VisitTimer(name, operation.Timer())
+	for _, outcome := range operation.Outcomes() {
+		visitor.VisitMeter(name+"."+outcome, operation.Outcome(outcome))
+	}
+}
+
+var standardOutcomes = []string{OutcomeError, OutcomeSuccess, OutcomeTimeout}
+
+type operationImpl struct {
+	name     string
+	registry *registryImpl
+	config   *metricConfig
+	timer    *timerImpl
+	refCount
+	idleTracker
+
+	// outcomes is replaced, never modified, when an outcome is added, so recording doesn't need a lock
+	outcomes     atomic.Pointer[operationOutcomes]
+	outcomesLock sync.Mutex
+	stopped      bool
+}
+
+type operationOutcomes struct {
+	names  []string
+	meters map[string]*meterImpl
+	// full is set once the limit on outcomes has been reached, and outcomes are counted under OutcomeOther
+	full bool
+}
+
+func (self *operationImpl) Start() OperationStopwatch {
+	return OperationStopwatch{
+		operation: self,
+		start:     time.Now(),
+	}
+}
+
+func (self *operationImpl) Time(f func() error) error {
+	start := time.Now()
+	err := f()
+	self.Record(OutcomeOf(err), time.Since(start))
+	return err
+}
+
+func (self *operationImpl) Record(outcome string, duration time.Duration) {
+	self.markUpdated()
+	self.timer.Update(duration)
+	if meter := self.outcomeMeter(outcome); meter != nil {
+		meter.Mark(1)
+	}
+}
+
+func (self *operationImpl) outcomeMeter(outcome string) *meterImpl {
+	outcomes := self.outcomes.Load()
+	if meter, found := outcomes.meters[outcome]; found {
+		return meter
+	}
+	if outcomes.full {
+		return outcomes.meters[OutcomeOther]
+	}
+
+	self.outcomesLock.Lock()
+	defer self.outcomesLock.Unlock()
+
+	current := self.outcomes.Load()
+	if meter, found := current.meters[outcome]; found {
+		return meter
+	}
+	if current.full {
+		return current.meters[OutcomeOther]
+	}
+	if self.stopped {
+		// the operation has been removed from the registry, so don't create meters which would never be stopped
+		return nil
+	}
+
+	next := &operationOutcomes{
+		names:  slices.Clone(current.names),
+		meters: make(map[string]*meterImpl, len(current.meters)+1),
+		full:   len(current.names) >= maxOperationOutcomes,
+	}
+	for k, v := range current.meters {
+		next.meters[k] = v
+	}
+	if next.full {
+		outcome = OutcomeOther
+	}
+
+	meter, found := next.meters[outcome]
+	if !found {
+		meter = self.registry.newMeter(self.name+"."+outcome, self.config)
+		next.names = append(next.names, outcome)
+		slices.Sort(next.names)
+		next.meters[outcome] = meter
+	}
+	self.outcomes.Store(next)
+	return meter
+}
+
+func (self *operationImpl) Timer() Timer {
+	return self.timer.CreateSnapshot()
+}
+
+func (self *operationImpl) Outcome(outcome string) Meter {
+	if meter, found := self.outcomes.Load().meters[outcome]; found {
+		return meter
+	}
+	return nil
+}
+
+func (self *operationImpl) Outcomes() []string {
+	return self.outcomes.Load().names
+}
+
+func (self *operationImpl) Name() string {
+	return self.name
+}
+
+func (self *operationImpl) Dispose() {
+	self.registry.disposeRefCounted(self)
+}
+
+func (self *operationImpl) stop() {
+	self.outcomesLock.Lock()
+	defer self.outcomesLock.Unlock()
+
+	self.stopped = true
+	self.timer.stop()
+	for _, meter := range self.outcomes.Load().meters {
+		meter.stop()
+	}
+}
+
+func (self *operationImpl) AcceptVisitor(name string, visitor Visitor) {
+	visitOperation(name, self, visitor)
+}
+
+func (registry *registryImpl) newOperation(name string, config *metricConfig) *operationImpl {
+	result := &operationImpl{
+		name:     name,
+		registry: registry,
+		config:   config,
+		timer:    registry.newTimer(name, config),
+	}
+	outcomes := &operationOutcomes{
+		names:  standardOutcomes,
+		meters: map[string]*meterImpl{},
+	}
+	for _, outcome := range standardOutcomes {
+		outcomes.meters[outcome] = registry.newMeter(name+"."+outcome, config)
+	}
+	result.outcomes.Store(outcomes)
+	return result
+}
+
+func (registry *registryImpl) Operation(name string, options ...MetricOption) Operation {
+	operation, err := registry.TryOperation(name, options...)
+	return handleCreateError(registry, name, operation, err, newNoopOperation)
+}
+
+func (registry *registryImpl) TryOperation(name string, options ...MetricOption) (Operation, error) {
+	config := newMetricConfig(options)
+	if err := validateRateWindows(config); err != nil {
+		return nil, err
+	}
+	return getOrCreateRefCounted[Operation](registry, name, MetricTypeOperation, func(name string) refCounted {
+		return registry.newOperation(name, config)
+	})
+}
+
+type noopOperation struct{}
+
+func newNoopOperation() Operation {
+	return noopOperation{}
+}
+
+func (self noopOperation) Start() OperationStopwatch {
+	return OperationStopwatch{operation: self, start: time.Now()}
+}
+
+func (noopOperation) Time(f func() error) error    { return f() }
+func (noopOperation) Record(string, time.Duration) {}
+func (noopOperation) Timer() Timer                 { return newNoopTimer() }
+func (noopOperation) Outcome(string) Meter         { return nil }
+func (noopOperation) Outcomes() []string           { return nil }
+func (noopOperation) Dispose()                     {}
@@ -0,0 +1,142 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type statusError struct {
+	code string
+}
+
+func (self statusError) Error() string {
+	return "status " + self.code
+}
+
+func (self statusError) Outcome() string {
+	return self.code
+}
+
+func TestOutcomeOf(t *testing.T) {
+	require.Equal(t, OutcomeSuccess, OutcomeOf(nil))
+	require.Equal(t, OutcomeError, OutcomeOf(errors.New("failed")))
+	require.Equal(t, OutcomeTimeout, OutcomeOf(context.DeadlineExceeded))
+	require.Equal(t, OutcomeTimeout, OutcomeOf(fmt.Errorf("dial failed: %w", context.DeadlineExceeded)))
+	require.Equal(t, "not_found", OutcomeOf(fmt.Errorf("lookup failed: %w", statusError{code: "not_found"})))
+}
+
+func TestOperation(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	operation := registry.Operation("rpc.create")
+
+	require.NoError(t, operation.Time(func() error { return nil }))
+	require.Error(t, operation.Time(func() error { return errors.New("failed") }))
+	stopwatch := operation.Start()
+	stopwatch.DoneErr(context.DeadlineExceeded)
+	operation.Record("not_found", time.Millisecond)
+
+	require.Equal(t, int64(4), operation.Timer().Count())
+	require.Equal(t, []string{"error", "not_found", "success", "timeout"}, operation.Outcomes())
+	require.Equal(t, int64(1), operation.Outcome(OutcomeSuccess).Count())
+	require.Equal(t, int64(1), operation.Outcome(OutcomeError).Count())
+	require.Equal(t, int64(1), operation.Outcome(OutcomeTimeout).Count())
+	require.Equal(t, int64(1), operation.Outcome("not_found").Count())
+	require.Nil(t, operation.Outcome("unknown"))
+
+	// operations are stored under a single name
+	require.Same(t, operation, registry.Operation("rpc.create"))
+	require.False(t, registry.IsValidMetric("rpc.create.success"))
+
+	operation.Dispose()
+	require.True(t, registry.IsValidMetric("rpc.create"))
+	operation.Dispose()
+	require.False(t, registry.IsValidMetric("rpc.create"))
+
+	// recording after removal doesn't create meters which would never be stopped
+	operation.Record("late", time.Millisecond)
+	require.Nil(t, operation.Outcome("late"))
+}
+
+func TestOperationOutcomeLimit(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	operation := registry.Operation("rpc.create")
+	defer operation.Dispose()
+
+	for i := range 2 * maxOperationOutcomes {
+		operation.Record(fmt.Sprintf("status_%v", i), time.Millisecond)
+	}
+
+	// once the outcome set is full, new outcomes are counted together
+	require.Len(t, operation.Outcomes(), maxOperationOutcomes+1)
+	require.Equal(t, int64(1), operation.Outcome("status_0").Count())
+	require.Nil(t, operation.Outcome(fmt.Sprintf("status_%v", maxOperationOutcomes)))
+	require.Equal(t, int64(maxOperationOutcomes+len(standardOutcomes)), operation.Outcome(OutcomeOther).Count())
+	require.Equal(t, int64(2*maxOperationOutcomes), operation.Timer().Count())
+}
+
+func TestOperationReporting(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	operation := registry.Operation("rpc")
+	defer operation.Dispose()
+
+	operation.Record(OutcomeSuccess, time.Millisecond)
+	operation.Record(OutcomeSuccess, time.Millisecond)
+	operation.Record(OutcomeError, time.Millisecond)
+
+	sink := report(registry)
+	require.Equal(t, int64(3), sink.ints["rpc.count"])
+	require.Equal(t, int64(2), sink.ints["rpc.success.count"])
+	require.Equal(t, int64(1), sink.ints["rpc.error.count"])
+	require.Equal(t, int64(0), sink.ints["rpc.timeout.count"])
+	require.Contains(t, sink.floats, "rpc.success.rate_m1")
+
+	sink = report(registry, WithOutcomeLabels())
+	require.Equal(t, int64(3), sink.ints["rpc.count"])
+	require.Equal(t, int64(2), sink.ints[`rpc.count{outcome="success"}`])
+	require.Equal(t, int64(1), sink.ints[`rpc.count{outcome="error"}`])
+	require.Contains(t, sink.floats, `rpc.rate_m1{outcome="success"}`)
+}
+
+func TestOperationVisitorFallback(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	operation := registry.Operation("rpc")
+	defer operation.Dispose()
+	operation.Record(OutcomeSuccess, time.Millisecond)
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, int64(1), visitor.timers["rpc"].Count())
+	require.Equal(t, int64(1), visitor.meters["rpc.success"].Count())
+	require.Equal(t, int64(0), visitor.meters["rpc.error"].Count())
+}
+
+func TestOperationTypeConflict(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("rpc")
+	defer timer.Dispose()
+
+	_, err := registry.TryOperation("rpc")
+	require.Error(t, err)
+	require.Equal(t, MetricTypeOperation, MetricTypeOf(newNoopOperation()))
+}
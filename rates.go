@@ -0,0 +1,122 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// WithRateWindows adds exponentially weighted moving average rates over the given windows to a meter or
+// timer, in addition to the standard one, five and fifteen minute rates. The rates are read using Rate,
+// and are reported by the DelegatingReporter with a suffix derived from the window, such as rate_10s.
+// Metrics with custom windows always use native meters, see WithNativeMeters. To follow windows of a few
+// seconds, their rates are updated more often than every five seconds, which makes the standard rates
+// differ slightly from those of go-metrics meters. Windows must be positive, otherwise creating the metric
+// fails with an error wrapping ErrInvalidWindow.
+func WithRateWindows(windows ...time.Duration) MetricOption {
+	return func(config *metricConfig) {
+		config.rateWindows = append(config.rateWindows, windows...)
+	}
+}
+
+// validateRateWindows checks that the windows given with WithRateWindows are positive
+func validateRateWindows(config *metricConfig) error {
+	for _, window := range config.rateWindows {
+		if window <= 0 {
+			return fmt.Errorf("%w: rate window %v isn't positive", ErrInvalidWindow, window)
+		}
+	}
+	return nil
+}
+
+// RateWindowName returns the name a rate over the given window is reported under, for example rate_10s
+// for a ten second window
+func RateWindowName(window time.Duration) string {
+	switch {
+	case window%time.Hour == 0:
+		return fmt.Sprintf("rate_%dh", window/time.Hour)
+	case window%time.Minute == 0:
+		return fmt.Sprintf("rate_%dm", window/time.Minute)
+	case window%time.Second == 0:
+		return fmt.Sprintf("rate_%ds", window/time.Second)
+	default:
+		return fmt.Sprintf("rate_%dms", window/time.Millisecond)
+	}
+}
+
+type standardRateSource interface {
+	Rate1() float64
+	Rate5() float64
+	Rate15() float64
+}
+
+// WindowedRates is implemented by meters and timers which have rates over custom windows, as given by
+// WithRateWindows. The meters and timers created by a registry implement it, as do their snapshots
+type WindowedRates interface {
+	// Rate returns the rate over the given window, which must be one of the standard one, five or fifteen
+	// minute windows, or one of the custom windows. Returns 0 otherwise
+	Rate(window time.Duration) float64
+	// RateWindows returns the custom windows
+	RateWindows() []time.Duration
+}
+
+// Rate returns the rate of the given meter or timer over the given window, which must be one of the
+// standard one, five or fifteen minute windows, or a window the metric was created with using
+// WithRateWindows. Returns 0 otherwise, and for other types of metric
+func Rate(metric Metric, window time.Duration) float64 {
+	if source, ok := metric.(standardRateSource); ok {
+		return rateOf(source, window)
+	}
+	return 0
+}
+
+// RateWindows returns the custom windows the given meter or timer was created with
+func RateWindows(metric Metric) []time.Duration {
+	return rateWindowsOf(metric)
+}
+
+// rateOf returns the rate over the given window from the given go-metrics meter or timer. Meters without
+// custom windows only have the standard one, five and fifteen minute rates, and return 0 for other windows
+func rateOf(source standardRateSource, window time.Duration) float64 {
+	if windowed, ok := source.(WindowedRates); ok {
+		return windowed.Rate(window)
+	}
+	return standardRate(source, window)
+}
+
+// standardRate returns the rate over the given window if it's one of the standard one, five and fifteen
+// minute windows, and 0 otherwise
+func standardRate(source standardRateSource, window time.Duration) float64 {
+	switch window {
+	case time.Minute:
+		return source.Rate1()
+	case 5 * time.Minute:
+		return source.Rate5()
+	case 15 * time.Minute:
+		return source.Rate15()
+	}
+	return 0
+}
+
+// rateWindowsOf returns the custom rate windows of the given go-metrics meter or timer
+func rateWindowsOf(source any) []time.Duration {
+	if windowed, ok := source.(WindowedRates); ok {
+		return windowed.RateWindows()
+	}
+	return nil
+}
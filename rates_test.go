@@ -0,0 +1,96 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateWindowName(t *testing.T) {
+	require.Equal(t, "rate_10s", RateWindowName(10*time.Second))
+	require.Equal(t, "rate_90s", RateWindowName(90*time.Second))
+	require.Equal(t, "rate_2m", RateWindowName(2*time.Minute))
+	require.Equal(t, "rate_1h", RateWindowName(time.Hour))
+	require.Equal(t, "rate_500ms", RateWindowName(500*time.Millisecond))
+}
+
+func TestNativeMeterRateWindows(t *testing.T) {
+	clock := &fakeClock{now: time.Now().UnixNano()}
+	meter := newNativeMeterWithClock(clock.nanotime, 10*time.Second, time.Hour)
+	require.Equal(t, []time.Duration{10 * time.Second, time.Hour}, meter.RateWindows())
+	require.Equal(t, time.Second, meter.tickInterval)
+
+	for i := 0; i < 60; i++ {
+		meter.Mark(10)
+		clock.advance(time.Second)
+	}
+	require.InDelta(t, 10, meter.Rate(10*time.Second), 0.001)
+	require.InDelta(t, 10, meter.Rate(time.Hour), 0.001)
+
+	// the short window reacts to a pause within seconds, while the long one barely moves
+	clock.advance(20 * time.Second)
+	require.Less(t, meter.Rate(10*time.Second), 1.5)
+	require.Greater(t, meter.Rate(time.Hour), 9.9)
+
+	require.Equal(t, meter.Rate1(), meter.Rate(time.Minute))
+	require.Equal(t, 0.0, meter.Rate(2*time.Second))
+
+	snapshot := meter.snapshot()
+	require.Equal(t, meter.Rate(10*time.Second), snapshot.Rate(10*time.Second))
+	require.Equal(t, meter.Rate5(), snapshot.Rate(5*time.Minute))
+}
+
+func TestInvalidRateWindows(t *testing.T) {
+	registry := NewRegistry("test", nil)
+
+	_, err := registry.TryMeter("meter", WithRateWindows(10*time.Second, 0))
+	require.ErrorIs(t, err, ErrInvalidWindow)
+	_, err = registry.TryTimer("timer", WithRateWindows(-time.Second))
+	require.ErrorIs(t, err, ErrInvalidWindow)
+	_, err = registry.TryOperation("operation", WithRateWindows(0))
+	require.ErrorIs(t, err, ErrInvalidWindow)
+	require.False(t, registry.IsValidMetric("meter"))
+
+	require.Panics(t, func() {
+		registry.Meter("meter", WithRateWindows(-time.Minute))
+	})
+}
+
+func TestReporterRateWindows(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Meter("meter", WithRateWindows(10*time.Second, time.Hour)).Mark(5)
+	timer := registry.Timer("timer", WithRateWindows(30*time.Second))
+	timer.Update(time.Millisecond)
+	registry.Meter("plain").Mark(1)
+
+	require.Equal(t, []time.Duration{30 * time.Second}, RateWindows(timer))
+	require.Equal(t, timer.Rate1(), Rate(timer, time.Minute))
+	require.Zero(t, Rate(newNoopGauge(), time.Minute))
+
+	sink := report(registry)
+	require.Contains(t, sink.floats, "meter.rate_10s")
+	require.Contains(t, sink.floats, "meter.rate_1h")
+	require.Contains(t, sink.floats, "timer.rate_30s")
+	require.Equal(t, int64(1), sink.ints["timer.count"])
+	require.NotContains(t, sink.floats, "plain.rate_10s")
+
+	sink = report(registry, WithMetricFields(MetricTypeMeter, MetricNameCount))
+	require.NotContains(t, sink.floats, "meter.rate_10s")
+}
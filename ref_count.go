@@ -0,0 +1,66 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// refCountRemoved is stored in the reference count of a metric which has been removed from its registry.
+// It's far enough below zero that stray releases of outstanding references can't bring it back above zero
+const refCountRemoved = math.MinInt32 / 2
+
+// refCount is embedded in metrics shared by several users, and counts how many of them hold a reference.
+// Metrics stored in a registry are only acquired through IncrRefCount while the registry holds the lock
+// for their name. Once a metric's count has dropped to zero, or it has been removed from its registry, it
+// can no longer be acquired through tryIncrRefCount, which lets cached handles acquire it without going
+// through the registry.
+type refCount struct {
+	count atomic.Int32
+}
+
+func (self *refCount) IncrRefCount() int32 {
+	return self.count.Add(1)
+}
+
+func (self *refCount) DecrRefCount() int32 {
+	return self.count.Add(-1)
+}
+
+// tryIncrRefCount acquires another reference, if the metric is still in use, and so still in its registry
+func (self *refCount) tryIncrRefCount() (int32, bool) {
+	for {
+		current := self.count.Load()
+		if current <= 0 {
+			return current, false
+		}
+		if self.count.CompareAndSwap(current, current+1) {
+			return current + 1, true
+		}
+	}
+}
+
+// markRemoved records that the metric has been removed from its registry
+func (self *refCount) markRemoved() {
+	self.count.Store(refCountRemoved)
+}
+
+// refs returns the number of outstanding references to the metric
+func (self *refCount) refs() int32 {
+	return self.count.Load()
+}
@@ -17,9 +17,13 @@
 package metrics
 
 import (
-	"fmt"
+	"encoding/json"
 	"log/slog"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/rcrowley/go-metrics"
@@ -30,59 +34,251 @@ type Metric interface {
 	Dispose()
 }
 
-// Registry allows for configuring and accessing metrics for an application
-type Registry interface {
+// MetricType identifies the kind of a metric
+type MetricType string
+
+const (
+	MetricTypeGauge         MetricType = "gauge"
+	MetricTypeGaugeFloat64  MetricType = "gauge_float64"
+	MetricTypeMeter         MetricType = "meter"
+	MetricTypeHistogram     MetricType = "histogram"
+	MetricTypeTimer         MetricType = "timer"
+	MetricTypeWindowCounter MetricType = "window_counter"
+	MetricTypeCounter       MetricType = "counter"
+	MetricTypeOperation     MetricType = "operation"
+
+	// MetricTypeRollup is only used in errors. Rollups are reported as the type of metric they aggregate
+	MetricTypeRollup MetricType = "rollup"
+)
+
+// RegistryReader is the read side of a Registry, which is all that is needed to report its metrics
+type RegistryReader interface {
 	// SourceId returns the source id of this Registry
 	SourceId() string
 
+	// EachMetric calls the given visitor function for each Metric in this registry
+	EachMetric(visitor func(name string, metric Metric))
+
+	// GetGauge returns the Gauge for the given name or nil if a Gauge with that name doesn't exist
+	GetGauge(name string) Gauge
+
+	// GetGaugeFloat64 returns the GaugeFloat64 for the given name or nil if one doesn't exist
+	GetGaugeFloat64(name string) GaugeFloat64
+
+	// GetMeter returns the Meter for the given name or nil if a Meter with that name doesn't exist
+	GetMeter(name string) Meter
+
+	// GetHistogram returns the Histogram for the given name or nil if a Histogram with that name doesn't exist
+	GetHistogram(name string) Histogram
+
+	// GetTimer returns the Timer for the given name or nil if a Timer with that name doesn't exist
+	GetTimer(name string) Timer
+
+	// IsValidMetric returns true if a metric with the given name exists in the registry, false otherwise
+	IsValidMetric(name string) bool
+
+	AcceptVisitor(visitor Visitor)
+
+	// Metadata returns the metadata for the given name, with the type and unit filled in from the metric,
+	// if it exists. Returns false if the metric doesn't exist and no metadata has been registered for it
+	Metadata(name string) (Metadata, bool)
+
+	// Describe calls the given visitor with the metadata of each metric in the registry
+	Describe(visitor DescribeVisitor)
+}
+
+// Registry allows for configuring and accessing metrics for an application
+type Registry interface {
+	RegistryReader
+
 	// Gauge returns a Gauge for the given name. If one does not yet exist, one will be created
-	Gauge(name string) Gauge
+	Gauge(name string, options ...MetricOption) Gauge
 
 	// FuncGauge returns a Gauge for the given name. If one does not yet exist, one will be created using
 	// the given function
-	FuncGauge(name string, f func() int64) Gauge
+	FuncGauge(name string, f func() int64, options ...MetricOption) Gauge
 
 	// GaugeFloat64 returns a GaugeFloat64 for the given name. If one does not yet exist, one will be created
-	GaugeFloat64(name string) GaugeFloat64
+	GaugeFloat64(name string, options ...MetricOption) GaugeFloat64
 
 	// FuncGaugeFloat64 returns a GaugeFloat64 for the given name. If one does not yet exist, one will be created
 	// using the given function
-	FuncGaugeFloat64(name string, f func() float64) GaugeFloat64
+	FuncGaugeFloat64(name string, f func() float64, options ...MetricOption) GaugeFloat64
+
+	// RatioGauge returns a GaugeFloat64 for the given name. If one does not yet exist, one will be created
+	// which divides the numerator by the denominator each time it's read. Both are references to other
+	// metrics in the registry, as described for ExpressionGauge, for example errors.rate_m1
+	RatioGauge(name string, numerator string, denominator string, options ...MetricOption) GaugeFloat64
+
+	// ExpressionGauge returns a GaugeFloat64 for the given name. If one does not yet exist, one will be
+	// created which evaluates the given expression each time it's read. Expressions combine numbers and
+	// references to other metrics using +, -, *, / and parentheses, for example
+	//
+	//	100 * errors.rate_m1 / (requests.rate_m1 + errors.rate_m1)
+	//
+	// A reference is either the name of a gauge, float gauge or counter, or the name of a metric followed by
+	// one of its fields, named as the DelegatingReporter names them. Meters have count, rate_m1, rate_m5,
+	// rate_m15 and rate_mean. Histograms have count, mean, min, max, std_dev,
+	// variance, sum and percentiles such as p50 and p999. Timers and operations have the fields of both, with
+	// mean being the mean duration. The meter of each outcome of an operation is referenced as
+	// <name>.<outcome>, for example dial.success.count. Window counters have count and rate. Rates over
+	// custom windows are named as given by RateWindowName, for example rate_10s. Rollups and other computed
+	// gauges are referenced like the metrics they're visited as. References start with a letter or '_', and
+	// may contain letters, digits, '_' and '.'. Names with other characters can be written in braces, for
+	// example {link-1.tx.bytes.count}.
+	//
+	// A reference to a metric which doesn't exist, or has been disposed, evaluates to NaN, as does division
+	// by zero and a computed gauge which references itself, directly or through other computed gauges. A
+	// computed gauge whose value isn't a finite number isn't visited, so reporters skip it until its inputs
+	// are available. Calling Update on a computed gauge has no effect.
+	ExpressionGauge(name string, expression string, options ...MetricOption) GaugeFloat64
 
 	// Meter returns a Meter for the given name. If one does not yet exist, one will be created
-	Meter(name string) Meter
+	Meter(name string, options ...MetricOption) Meter
 
 	// Histogram returns a Histogram for the given name. If one does not yet exist, one will be created
-	Histogram(name string) Histogram
+	Histogram(name string, options ...MetricOption) Histogram
 
 	// Timer returns a Timer for the given name. If one does not yet exist, one will be created
-	Timer(name string) Timer
+	Timer(name string, options ...MetricOption) Timer
 
-	// EachMetric calls the given visitor function for each Metric in this registry
-	EachMetric(visitor func(name string, metric Metric))
+	// WindowCounter returns a WindowCounter for the given name. If one does not yet exist, one will be
+	// created, counting events over the given window using the given number of buckets. A single bucket
+	// makes a tumbling window, which is reset each time the window elapses, rather than a sliding one
+	WindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) WindowCounter
 
-	// GetGauge returns the Gauge for the given name or nil if a Gauge with that name doesn't exist
-	GetGauge(name string) Gauge
+	// Counter returns a Counter for the given name. If one does not yet exist, one will be created
+	Counter(name string, options ...MetricOption) Counter
 
-	// GetGaugeFloat64 returns the GaugeFloat64 for the given name or nil if one doesn't exist
-	GetGaugeFloat64(name string) GaugeFloat64
+	// Operation returns an Operation for the given name. If one does not yet exist, one will be created
+	Operation(name string, options ...MetricOption) Operation
 
-	// GetMeter returns the Meter for the given name or nil if a Meter with that name doesn't exist
-	GetMeter(name string) Meter
+	// Rollup returns a Rollup for the given name, which aggregates the metrics of the given type matching the
+	// given pattern. If one does not yet exist, one will be created
+	Rollup(name string, pattern string, metricType MetricType, aggregation Aggregation, options ...MetricOption) Rollup
 
-	// GetHistogram returns the Histogram for the given name or nil if a Histogram with that name doesn't exist
-	GetHistogram(name string) Histogram
+	// TryGauge is like Gauge, but returns an error instead of applying the registry's ErrorPolicy if a
+	// metric with the given name exists and is not a Gauge
+	TryGauge(name string, options ...MetricOption) (Gauge, error)
 
-	// GetTimer returns the Timer for the given name or nil if a Timer with that name doesn't exist
-	GetTimer(name string) Timer
+	// TryFuncGauge is like FuncGauge, but returns an error instead of applying the registry's ErrorPolicy
+	TryFuncGauge(name string, f func() int64, options ...MetricOption) (Gauge, error)
 
-	// IsValidMetric returns true if a metric with the given name exists in the registry, false otherwise
-	IsValidMetric(name string) bool
+	// TryGaugeFloat64 is like GaugeFloat64, but returns an error instead of applying the registry's ErrorPolicy
+	TryGaugeFloat64(name string, options ...MetricOption) (GaugeFloat64, error)
 
-	AcceptVisitor(visitor Visitor)
+	// TryFuncGaugeFloat64 is like FuncGaugeFloat64, but returns an error instead of applying the registry's ErrorPolicy
+	TryFuncGaugeFloat64(name string, f func() float64, options ...MetricOption) (GaugeFloat64, error)
+
+	// TryRatioGauge is like RatioGauge, but returns an error instead of applying the registry's ErrorPolicy
+	TryRatioGauge(name string, numerator string, denominator string, options ...MetricOption) (GaugeFloat64, error)
+
+	// TryExpressionGauge is like ExpressionGauge, but returns an error instead of applying the registry's
+	// ErrorPolicy, which includes the expression being invalid
+	TryExpressionGauge(name string, expression string, options ...MetricOption) (GaugeFloat64, error)
+
+	// TryMeter is like Meter, but returns an error instead of applying the registry's ErrorPolicy
+	TryMeter(name string, options ...MetricOption) (Meter, error)
+
+	// TryHistogram is like Histogram, but returns an error instead of applying the registry's ErrorPolicy
+	TryHistogram(name string, options ...MetricOption) (Histogram, error)
+
+	// TryTimer is like Timer, but returns an error instead of applying the registry's ErrorPolicy
+	TryTimer(name string, options ...MetricOption) (Timer, error)
+
+	// TryWindowCounter is like WindowCounter, but returns an error instead of applying the registry's ErrorPolicy
+	TryWindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) (WindowCounter, error)
+
+	// TryCounter is like Counter, but returns an error instead of applying the registry's ErrorPolicy
+	TryCounter(name string, options ...MetricOption) (Counter, error)
+
+	// TryOperation is like Operation, but returns an error instead of applying the registry's ErrorPolicy
+	TryOperation(name string, options ...MetricOption) (Operation, error)
+
+	// TryRollup is like Rollup, but returns an error instead of applying the registry's ErrorPolicy, which
+	// includes the pattern, metric type or aggregation being invalid, or differing from those of an existing
+	// rollup with the given name
+	TryRollup(name string, pattern string, metricType MetricType, aggregation Aggregation, options ...MetricOption) (Rollup, error)
+
+	// Register adds the given metric under the given name, returning a metrics.DuplicateMetric error if the
+	// name is already in use. Besides the metric types provided by this package, metrics implementing
+	// Visitable and go-metrics metrics can be registered, and will be visited by AcceptVisitor
+	Register(name string, metric interface{}) error
+
+	// Unregister removes the metric with the given name, regardless of any outstanding references to it,
+	// and releases its resources
+	Unregister(name string)
+
+	// SetMetadata registers metadata for the metric with the given name. The metric doesn't need to exist
+	// yet, and the metadata is kept if the metric is disposed and later recreated
+	SetMetadata(name string, metadata Metadata)
+
+	// Tags returns the tags of this Registry
+	Tags() map[string]string
+
+	// Child returns a Registry which shares storage with this one, and which stores its metrics under
+	// names prefixed with the given prefix and a '.'. Names passed to the child are relative to the prefix,
+	// while names reported by EachMetric, AcceptVisitor and Describe include it. The child's tags are this
+	// registry's tags merged with the given tags. DisposeAll on a child removes the metrics under its prefix
+	// at the time it's called, without looking at the rest of the registry. RefCountCheckpoint on a child
+	// takes a checkpoint of the whole registry, as checkpoints are shared with the root
+	Child(prefix string, tags map[string]string) Registry
 
 	// DisposeAll removes and cleans up all metrics currently in the Registry
 	DisposeAll()
+
+	// AddListener registers a listener which is notified when metrics are created, acquired, disposed and
+	// removed by DisposeAll. A listener added to a child registry only receives events for the child's metrics
+	AddListener(listener RegistryListener)
+
+	// RemoveListener removes a listener added with AddListener. Listeners which can't be compared, such as
+	// RegistryListenerF, can't be removed
+	RemoveListener(listener RegistryListener)
+
+	// RefCountCheckpoint marks the point by which outstanding references to reference counted metrics are
+	// expected to have been disposed. References acquired before it which are still outstanding are listed
+	// by RefCountReport. Has no effect unless the registry was created with WithRefCountTracking
+	RefCountCheckpoint()
+
+	// RefCountReport lists reference counted metrics with more references than expected, or with references
+	// which have survived past the last checkpoint. It's only populated if the registry was created with
+	// WithRefCountTracking
+	RefCountReport() RefCountReport
+}
+
+// Visitable is implemented by custom metric types which aren't one of the types provided by this
+// package. When a registry is visited, a Visitable metric is handed the visitor and should call the
+// Visitor methods which represent it, for example a set could visit a gauge holding its size. Register
+// custom metrics using Registry.Register.
+type Visitable interface {
+	Metric
+	AcceptVisitor(name string, visitor Visitor)
+}
+
+// MetricOption configures a metric as it is created. Options are ignored if a metric with the
+// requested name already exists
+type MetricOption func(config *metricConfig)
+
+type metricConfig struct {
+	unit        Unit
+	rateWindows []time.Duration
+	sharded     bool
+}
+
+func newMetricConfig(options []MetricOption) *metricConfig {
+	result := &metricConfig{}
+	for _, option := range options {
+		option(result)
+	}
+	return result
+}
+
+// WithUnit sets the unit of the values recorded by the metric, which is returned by UnitOf. Timers always
+// record nanoseconds, so this option has no effect on them
+func WithUnit(unit Unit) MetricOption {
+	return func(config *metricConfig) {
+		config.unit = unit
+	}
 }
 
 type Visitor interface {
@@ -93,29 +289,143 @@ type Visitor interface {
 	VisitTimer(name string, timer Timer)
 }
 
-func NewRegistry(sourceId string, tags map[string]string) Registry {
-	return &registryImpl{
+// ErrorPolicy determines what the registry's metric accessors, such as Meter and Histogram, do when a
+// metric can't be created, for example because the name is already used by a metric of a different type.
+// The Try variants of the accessors always return the error instead.
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyPanic panics with the error. This is the default
+	ErrorPolicyPanic ErrorPolicy = iota
+	// ErrorPolicyLogAndNoop logs the error and returns a no-op metric which isn't stored in the registry
+	ErrorPolicyLogAndNoop
+)
+
+// RegistryOption configures optional behavior of a Registry
+type RegistryOption func(registry *registryImpl)
+
+// WithErrorPolicy sets what the registry does when a metric can't be created
+func WithErrorPolicy(policy ErrorPolicy) RegistryOption {
+	return func(registry *registryImpl) {
+		registry.errorPolicy = policy
+	}
+}
+
+func NewRegistry(sourceId string, tags map[string]string, options ...RegistryOption) Registry {
+	result := &registryImpl{
 		sourceId:  sourceId,
 		tags:      tags,
 		metricMap: cmap.New[Metric](),
+		metadata:  cmap.New[Metadata](),
+	}
+	for _, option := range options {
+		option(result)
+	}
+	if result.idleExpiration != nil {
+		result.startIdleExpiration()
+	}
+	if len(result.cardinalityLimits) > 0 {
+		result.startCardinalityTracking()
 	}
+	return result
 }
 
 type registryImpl struct {
-	sourceId  string
-	tags      map[string]string
-	metricMap cmap.ConcurrentMap[string, Metric]
+	sourceId          string
+	tags              map[string]string
+	metricMap         cmap.ConcurrentMap[string, Metric]
+	names             nameIndex
+	metadata          cmap.ConcurrentMap[string, Metadata]
+	errorPolicy       ErrorPolicy
+	idleExpiration    *idleExpiration
+	cardinalityLimits []*cardinalityLimit
+	listeners         atomic.Pointer[[]*listenerEntry]
+	listenersLock     sync.Mutex
+	refCountTracker   *refCountTracker
+	nativeMeters      bool
 }
 
-func (registry *registryImpl) dispose(name string) {
-	registry.metricMap.Remove(name)
+// metricAdded is called after a metric with a new name has been stored in the registry
+func (registry *registryImpl) metricAdded(name string, metric Metric, refCount int32) {
+	registry.names.add(name)
+	registry.updateCardinality(name, 1)
+	registry.notify(RegistryEventCreated, name, metric, refCount)
 }
 
-func (registry *registryImpl) DisposeAll() {
-	registry.EachMetric(func(name string, metric Metric) {
-		metric.Dispose()
+// metricRemoved is called after a metric has been removed from the registry
+func (registry *registryImpl) metricRemoved(name string, metric Metric) {
+	registry.names.remove(name)
+	registry.updateCardinality(name, -1)
+	if registry.refCountTracker != nil {
+		registry.refCountTracker.removed(name, metric)
+	}
+	registry.notify(RegistryEventDisposed, name, metric, 0)
+}
+
+// remove removes the named metric, if it's the given metric
+func (registry *registryImpl) remove(name string, metric Metric) bool {
+	return registry.removeIf(name, func(existing Metric) bool {
+		return existing == metric
+	})
+}
+
+// removeIf removes the named metric if the given predicate, which is called while the entry is locked,
+// returns true
+func (registry *registryImpl) removeIf(name string, predicate func(existing Metric) bool) bool {
+	return registry.removeEntryIf(name, func(existing Metric) bool {
+		if predicate(existing) {
+			markRemoved(existing)
+			return true
+		}
+		return false
 	})
-	registry.metricMap.Clear()
+}
+
+// removeEntryIf is removeIf without marking the removed metric as removed, which leaves the reference
+// count of an evicted metric intact, for when it's put back
+func (registry *registryImpl) removeEntryIf(name string, predicate func(existing Metric) bool) bool {
+	var metric Metric
+	removed := registry.metricMap.RemoveCb(name, func(key string, v Metric, exists bool) bool {
+		metric = v
+		return exists && predicate(v)
+	})
+	if removed {
+		registry.metricRemoved(name, metric)
+	}
+	return removed
+}
+
+func (registry *registryImpl) DisposeAll() {
+	registry.disposeWithPrefix("")
+}
+
+// disposeWithPrefix removes all metrics whose names start with the given prefix, which must be empty or
+// end with a '.', regardless of any outstanding references to them, and releases their resources. The
+// metrics are found using the name index, so only the metrics under the prefix are looked at, and the
+// metrics removed are those which were registered at one point in time
+func (registry *registryImpl) disposeWithPrefix(prefix string) {
+	entries := metricEntriesPool.Get().(*[]metricEntry)
+	registry.indexedMetrics(prefix, entries)
+
+	for _, entry := range *entries {
+		if registry.remove(entry.name, entry.metric) {
+			stopMetric(entry.metric)
+		}
+	}
+
+	clear(*entries)
+	*entries = (*entries)[:0]
+	metricEntriesPool.Put(entries)
+
+	registry.notify(RegistryEventDisposeAll, prefix, nil, 0)
+}
+
+func (registry *registryImpl) Tags() map[string]string {
+	return registry.tags
+}
+
+func (registry *registryImpl) Child(prefix string, tags map[string]string) Registry {
+	return newChildRegistry(registry, prefix+".", mergeTags(registry.tags, tags))
 }
 
 func (registry *registryImpl) IsValidMetric(name string) bool {
@@ -181,198 +491,527 @@ func (registry *registryImpl) GetTimer(name string) Timer {
 	return nil
 }
 
-func getOrCreateMetric[T Metric](registry *registryImpl, name string, newMetric func() T) T {
+func getOrCreateMetric[T Metric](registry *registryImpl, name string, requested MetricType, newMetric func(name string) T) (T, error) {
 	var result T
+	name, err := registry.applyCardinalityLimits(name)
+	if err != nil {
+		return result, err
+	}
+
 	for {
 		metric, present := registry.metricMap.Get(name)
 		if present {
 			var ok bool
 			result, ok = metric.(T)
 			if !ok {
-				panic(fmt.Errorf("metric '%v' already exists and is not a %T. It is a %T", name, new(T), metric))
+				return result, newMetricTypeConflictError(name, metric, requested)
 			}
-			return result
+			markUsed(result)
+			return result, nil
 		}
 
-		result = newMetric()
+		result = newMetric(name)
 		if registry.metricMap.SetIfAbsent(name, result) {
-			return result
+			registry.metricAdded(name, result, 0)
+			return result, nil
 		}
 	}
 }
 
-func (registry *registryImpl) Gauge(name string) Gauge {
-	return getOrCreateMetric(registry, name, func() Gauge {
-		return &gaugeImpl{
+func getOrCreateRefCounted[T Metric](registry *registryImpl, name string, requested MetricType, factory func(name string) refCounted) (T, error) {
+	name, err := registry.applyCardinalityLimits(name)
+	if err != nil {
+		var result T
+		return result, err
+	}
+
+	var conflict Metric
+	var refCount int32
+	created := false
+	metric := registry.metricMap.Upsert(name, nil, func(exist bool, valueInMap Metric, newValue Metric) Metric {
+		if exist {
+			_, isT := valueInMap.(T)
+			h, isRefCounted := valueInMap.(refCounted)
+			if !isT || !isRefCounted {
+				conflict = valueInMap
+				return valueInMap
+			}
+			refCount = h.IncrRefCount()
+			markUsed(valueInMap)
+			return valueInMap
+		}
+
+		newVal := factory(name)
+		refCount = newVal.IncrRefCount()
+		created = true
+		return newVal
+	})
+
+	if conflict != nil {
+		var result T
+		return result, newMetricTypeConflictError(name, conflict, requested)
+	}
+
+	if registry.refCountTracker != nil {
+		// skip getOrCreateRefCounted
+		registry.trackAcquired(1, name, metric)
+	}
+
+	if created {
+		registry.metricAdded(name, metric, refCount)
+	} else {
+		registry.notify(RegistryEventRefCountChanged, name, metric, refCount)
+	}
+	return metric.(T), nil
+}
+
+// reacquired does the bookkeeping of getOrCreateRefCounted for a reference taken by a Handle, which
+// acquires the metric directly rather than through the metric map
+func (registry *registryImpl) reacquired(name string, metric Metric, refCount int32) {
+	markUsed(metric)
+	if registry.refCountTracker != nil {
+		// skip reacquired and Handle.Acquire
+		registry.trackAcquired(2, name, metric)
+	}
+	registry.notify(RegistryEventRefCountChanged, name, metric, refCount)
+}
+
+// trackAcquired records an acquisition with the ref count tracker, skipping the given number of callers
+// in the recorded stack, unless the metric has been removed since it was acquired
+func (registry *registryImpl) trackAcquired(skip int, name string, metric Metric) {
+	registry.refCountTracker.acquired(skip+1, name, metric, func() bool {
+		current, found := registry.metricMap.Get(name)
+		return found && current == metric
+	})
+}
+
+// handleCreateError applies the registry's ErrorPolicy to an error from one of the Try methods
+func handleCreateError[T Metric](registry *registryImpl, name string, metric T, err error, noop func() T) T {
+	if err == nil {
+		return metric
+	}
+	if registry.errorPolicy == ErrorPolicyLogAndNoop {
+		slog.Error("unable to create metric, returning no-op metric", "name", name, "error", err)
+		return noop()
+	}
+	panic(err)
+}
+
+func (registry *registryImpl) Gauge(name string, options ...MetricOption) Gauge {
+	gauge, err := registry.TryGauge(name, options...)
+	return handleCreateError(registry, name, gauge, err, newNoopGauge)
+}
+
+func (registry *registryImpl) TryGauge(name string, options ...MetricOption) (Gauge, error) {
+	return getOrCreateMetric(registry, name, MetricTypeGauge, func(name string) Gauge {
+		gauge := &gaugeImpl{
 			Gauge: metrics.NewGauge(),
-			dispose: func() {
-				registry.dispose(name)
-			},
+			unit:  newMetricConfig(options).unit,
+		}
+		gauge.dispose = func() {
+			registry.remove(name, gauge)
 		}
+		return gauge
 	})
 }
 
-func (registry *registryImpl) FuncGauge(name string, f func() int64) Gauge {
-	return getOrCreateMetric(registry, name, func() Gauge {
-		return &gaugeImpl{
-			Gauge: metrics.NewFunctionalGauge(f),
-			dispose: func() {
-				registry.dispose(name)
-			},
+func (registry *registryImpl) FuncGauge(name string, f func() int64, options ...MetricOption) Gauge {
+	gauge, err := registry.TryFuncGauge(name, f, options...)
+	return handleCreateError(registry, name, gauge, err, newNoopGauge)
+}
+
+func (registry *registryImpl) TryFuncGauge(name string, f func() int64, options ...MetricOption) (Gauge, error) {
+	return getOrCreateMetric(registry, name, MetricTypeGauge, func(name string) Gauge {
+		gauge := &gaugeImpl{
+			Gauge:       metrics.NewFunctionalGauge(f),
+			idleTracker: idleTracker{exempt: true},
+			unit:        newMetricConfig(options).unit,
 		}
+		gauge.dispose = func() {
+			registry.remove(name, gauge)
+		}
+		return gauge
 	})
 }
 
-func (registry *registryImpl) GaugeFloat64(name string) GaugeFloat64 {
-	return getOrCreateMetric(registry, name, func() GaugeFloat64 {
-		return &gaugeFloat64Impl{
+func (registry *registryImpl) GaugeFloat64(name string, options ...MetricOption) GaugeFloat64 {
+	gauge, err := registry.TryGaugeFloat64(name, options...)
+	return handleCreateError(registry, name, gauge, err, newNoopGaugeFloat64)
+}
+
+func (registry *registryImpl) TryGaugeFloat64(name string, options ...MetricOption) (GaugeFloat64, error) {
+	return getOrCreateMetric(registry, name, MetricTypeGaugeFloat64, func(name string) GaugeFloat64 {
+		gauge := &gaugeFloat64Impl{
 			GaugeFloat64: metrics.NewGaugeFloat64(),
-			dispose: func() {
-				registry.dispose(name)
-			},
+			unit:         newMetricConfig(options).unit,
 		}
+		gauge.dispose = func() {
+			registry.remove(name, gauge)
+		}
+		return gauge
 	})
 }
 
-func (registry *registryImpl) FuncGaugeFloat64(name string, f func() float64) GaugeFloat64 {
-	return getOrCreateMetric(registry, name, func() GaugeFloat64 {
-		return &gaugeFloat64Impl{
+func (registry *registryImpl) FuncGaugeFloat64(name string, f func() float64, options ...MetricOption) GaugeFloat64 {
+	gauge, err := registry.TryFuncGaugeFloat64(name, f, options...)
+	return handleCreateError(registry, name, gauge, err, newNoopGaugeFloat64)
+}
+
+func (registry *registryImpl) TryFuncGaugeFloat64(name string, f func() float64, options ...MetricOption) (GaugeFloat64, error) {
+	return getOrCreateMetric(registry, name, MetricTypeGaugeFloat64, func(name string) GaugeFloat64 {
+		gauge := &gaugeFloat64Impl{
 			GaugeFloat64: metrics.NewFunctionalGaugeFloat64(f),
-			dispose: func() {
-				registry.dispose(name)
-			},
+			idleTracker:  idleTracker{exempt: true},
+			unit:         newMetricConfig(options).unit,
 		}
+		gauge.dispose = func() {
+			registry.remove(name, gauge)
+		}
+		return gauge
 	})
 }
 
-func (registry *registryImpl) newMeter(name string) *meterImpl {
+func (registry *registryImpl) newMeter(name string, config *metricConfig) *meterImpl {
+	var meter metrics.Meter
+	if config.sharded {
+		meter = newShardedNativeMeter(config.rateWindows...)
+	} else if registry.nativeMeters || len(config.rateWindows) > 0 {
+		meter = newNativeMeter(config.rateWindows...)
+	} else {
+		meter = metrics.NewMeter()
+	}
 	return &meterImpl{
-		Meter:    metrics.NewMeter(),
+		Meter:    meter,
 		registry: registry,
 		name:     name,
+		unit:     config.unit,
 	}
 }
 
-func (registry *registryImpl) Meter(name string) Meter {
-	metric := registry.getRefCounted(name, func() refCounted {
-		return registry.newMeter(name)
-	})
+func (registry *registryImpl) Meter(name string, options ...MetricOption) Meter {
+	meter, err := registry.TryMeter(name, options...)
+	return handleCreateError(registry, name, meter, err, newNoopMeter)
+}
 
-	meter, ok := metric.(Meter)
-	if !ok {
-		panic(fmt.Errorf("metric '%v' already exists and is not a meter. It is a %v", name, reflect.TypeOf(metric).Name()))
+func (registry *registryImpl) TryMeter(name string, options ...MetricOption) (Meter, error) {
+	config := newMetricConfig(options)
+	if err := validateRateWindows(config); err != nil {
+		return nil, err
 	}
-	return meter
+	return getOrCreateRefCounted[Meter](registry, name, MetricTypeMeter, func(name string) refCounted {
+		return registry.newMeter(name, config)
+	})
 }
 
-func (registry *registryImpl) newHistogram(name string) *histogramImpl {
+func (registry *registryImpl) newHistogram(name string, config *metricConfig) *histogramImpl {
 	return &histogramImpl{
 		Histogram: metrics.NewHistogram(metrics.NewExpDecaySample(128, 0.015)),
 		registry:  registry,
 		name:      name,
+		unit:      config.unit,
 	}
 }
 
-func (registry *registryImpl) Histogram(name string) Histogram {
-	metric := registry.getRefCounted(name, func() refCounted {
-		return registry.newHistogram(name)
-	})
-
-	histogram, ok := metric.(Histogram)
-	if !ok {
-		panic(fmt.Errorf("metric '%v' already exists and is not a histogram. It is a %v", name, reflect.TypeOf(metric).Name()))
-	}
-	return histogram
+func (registry *registryImpl) Histogram(name string, options ...MetricOption) Histogram {
+	histogram, err := registry.TryHistogram(name, options...)
+	return handleCreateError(registry, name, histogram, err, newNoopHistogram)
 }
 
-func (registry *registryImpl) getRefCounted(name string, factory func() refCounted) refCounted {
-	metric := registry.metricMap.Upsert(name, nil, func(exist bool, valueInMap Metric, newValue Metric) Metric {
-		if exist {
-			if h, ok := valueInMap.(refCounted); ok {
-				h.IncrRefCount()
-			}
-			return valueInMap
-		}
-
-		newVal := factory()
-		newVal.IncrRefCount()
-		return newVal
+func (registry *registryImpl) TryHistogram(name string, options ...MetricOption) (Histogram, error) {
+	return getOrCreateRefCounted[Histogram](registry, name, MetricTypeHistogram, func(name string) refCounted {
+		return registry.newHistogram(name, newMetricConfig(options))
 	})
-
-	histogram, ok := metric.(refCounted)
-	if !ok {
-		panic(fmt.Errorf("metric '%v' already exists and is not an instance of refCouted. It is a %v", name, reflect.TypeOf(metric).Name()))
-	}
-	return histogram
 }
 
 func (registry *registryImpl) disposeRefCounted(metric refCounted) {
-	removed := registry.metricMap.RemoveCb(metric.Name(), func(key string, v Metric, exists bool) bool {
-		if !exists {
-			return true
+	// a metric which isn't in the registry has either been removed, in which case its count stays far below
+	// zero, or evicted, in which case its count has to be kept up to date for when it's put back
+	var refCount int32
+	removed := false
+	registry.metricMap.RemoveCb(metric.Name(), func(key string, existing Metric, exists bool) bool {
+		refCount = metric.DecrRefCount()
+		if exists && existing == metric && refCount < 1 {
+			markRemoved(metric)
+			removed = true
 		}
-		return v == metric && metric.DecrRefCount() < 1
+		return removed
 	})
 
 	if removed {
+		registry.metricRemoved(metric.Name(), metric)
 		metric.stop()
+	} else {
+		if registry.refCountTracker != nil {
+			registry.refCountTracker.released(metric.Name(), metric)
+		}
+		registry.notify(RegistryEventRefCountChanged, metric.Name(), metric, refCount)
 	}
 }
 
-func (registry *registryImpl) Timer(name string) Timer {
-	return getOrCreateMetric(registry, name, func() Timer {
-		return &timerImpl{
-			Timer: metrics.NewTimer(),
-			dispose: func() {
-				registry.dispose(name)
-			},
-		}
+func (registry *registryImpl) newTimer(name string, config *metricConfig) *timerImpl {
+	var timer metrics.Timer
+	if registry.nativeMeters || len(config.rateWindows) > 0 {
+		timer = newNativeTimer(config.rateWindows...)
+	} else {
+		timer = newNativeTimerWithMeter(metrics.NewMeter())
+	}
+	return &timerImpl{
+		Timer:       timer,
+		registry:    registry,
+		name:        name,
+		rateWindows: config.rateWindows,
+	}
+}
+
+func (registry *registryImpl) Timer(name string, options ...MetricOption) Timer {
+	timer, err := registry.TryTimer(name, options...)
+	return handleCreateError(registry, name, timer, err, newNoopTimer)
+}
+
+func (registry *registryImpl) TryTimer(name string, options ...MetricOption) (Timer, error) {
+	return registry.tryTimer(name, true, options...)
+}
+
+// tryTimer gets or creates the named timer. If withOutcomes is set, a newly created timer creates the
+// sub-timers used by TimeErr once it's registered
+func (registry *registryImpl) tryTimer(name string, withOutcomes bool, options ...MetricOption) (Timer, error) {
+	config := newMetricConfig(options)
+	if err := validateRateWindows(config); err != nil {
+		return nil, err
+	}
+	var created *timerImpl
+	timer, err := getOrCreateMetric(registry, name, MetricTypeTimer, func(name string) Timer {
+		created = registry.newTimer(name, config)
+		return created
 	})
+	if err == nil && withOutcomes && created != nil && timer == Timer(created) {
+		created.createOutcomes()
+	}
+	return timer, err
+}
+
+// outcomeTimer gets or creates a sub-timer used by TimeErr. Sub-timers don't get sub-timers of their own,
+// and are replaced by a no-op timer if they can't be created, rather than applying the ErrorPolicy
+func (registry *registryImpl) outcomeTimer(name string, rateWindows []time.Duration) Timer {
+	timer, err := registry.tryTimer(name, false, WithRateWindows(rateWindows...))
+	if err != nil {
+		slog.Error("unable to create outcome timer, returning no-op timer", "name", name, "error", err)
+		return newNoopTimer()
+	}
+	return timer
 }
 
 func (registry *registryImpl) EachMetric(visitor func(name string, metric Metric)) {
-	for entry := range registry.metricMap.IterBuffered() {
-		visitor(entry.Key, entry.Val)
+	registry.eachMetricWithPrefix("", visitor)
+}
+
+type metricEntry struct {
+	name   string
+	metric Metric
+}
+
+// metricEntriesPool holds the buffers used to iterate over registries, so reporting doesn't allocate
+var metricEntriesPool = sync.Pool{
+	New: func() any {
+		return &[]metricEntry{}
+	},
+}
+
+// eachMetricWithPrefix calls the visitor with each metric whose name starts with the given prefix. The
+// visitor is called without holding any registry locks, so it may use the registry
+func (registry *registryImpl) eachMetricWithPrefix(prefix string, visitor func(name string, metric Metric)) {
+	entries := metricEntriesPool.Get().(*[]metricEntry)
+	if prefix != "" && strings.HasSuffix(prefix, ".") {
+		registry.indexedMetrics(prefix, entries)
+	} else {
+		registry.metricMap.IterCb(func(name string, metric Metric) {
+			if strings.HasPrefix(name, prefix) {
+				*entries = append(*entries, metricEntry{name: name, metric: metric})
+			}
+		})
+	}
+
+	for _, entry := range *entries {
+		visitor(entry.name, entry.metric)
 	}
+
+	clear(*entries)
+	*entries = (*entries)[:0]
+	metricEntriesPool.Put(entries)
+}
+
+// indexedMetrics appends the metrics whose names start with the given prefix, which must be empty or end
+// with a '.', to the given entries. Names which are removed while they're being looked up are skipped
+func (registry *registryImpl) indexedMetrics(prefix string, entries *[]metricEntry) {
+	registry.names.eachName(prefix, func(name string) {
+		*entries = append(*entries, metricEntry{name: name})
+	})
+
+	found := (*entries)[:0]
+	for _, entry := range *entries {
+		if metric, exists := registry.metricMap.Get(entry.name); exists {
+			found = append(found, metricEntry{name: entry.name, metric: metric})
+		}
+	}
+	clear((*entries)[len(found):])
+	*entries = found
 }
 
 func (registry *registryImpl) Each(visitor func(string, interface{})) {
 	for entry := range registry.metricMap.IterBuffered() {
-		visitor(entry.Key, entry.Val)
+		visitor(entry.Key, unwrapMetric(entry.Val))
 	}
 }
 
 // Provide rest of go-metrics Registry interface, so we can use go-metrics reporters if desired
+var _ metrics.Registry = (*registryImpl)(nil)
+
 func (registry *registryImpl) Get(s string) interface{} {
 	val, _ := registry.metricMap.Get(s)
 	return unwrapMetric(val)
 }
 
 func (registry *registryImpl) GetAll() map[string]map[string]interface{} {
-	return nil
+	data := make(map[string]map[string]interface{})
+	registry.Each(func(name string, i interface{}) {
+		data[name] = goMetricsValues(i)
+	})
+	return data
+}
+
+// MarshalJSON returns the values of all metrics in the registry, in the same format as go-metrics
+// registries, so metrics.WriteJSON can be used with this registry
+func (registry *registryImpl) MarshalJSON() ([]byte, error) {
+	return json.Marshal(registry.GetAll())
+}
+
+// goMetricsValues returns the values go-metrics reports in GetAll for the given metric
+func goMetricsValues(i interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	switch metric := i.(type) {
+	case metrics.Counter:
+		values["count"] = metric.Count()
+	case metrics.Gauge:
+		values["value"] = metric.Value()
+	case metrics.GaugeFloat64:
+		values["value"] = metric.Value()
+	case metrics.Healthcheck:
+		values["error"] = nil
+		metric.Check()
+		if err := metric.Error(); err != nil {
+			values["error"] = err.Error()
+		}
+	case metrics.Histogram:
+		h := metric.Snapshot()
+		ps := h.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+		values["count"] = h.Count()
+		values["min"] = h.Min()
+		values["max"] = h.Max()
+		values["mean"] = h.Mean()
+		values["stddev"] = h.StdDev()
+		values["median"] = ps[0]
+		values["75%"] = ps[1]
+		values["95%"] = ps[2]
+		values["99%"] = ps[3]
+		values["99.9%"] = ps[4]
+	case metrics.Meter:
+		m := metric.Snapshot()
+		values["count"] = m.Count()
+		values["1m.rate"] = m.Rate1()
+		values["5m.rate"] = m.Rate5()
+		values["15m.rate"] = m.Rate15()
+		values["mean.rate"] = m.RateMean()
+	case metrics.Timer:
+		t := metric.Snapshot()
+		ps := t.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+		values["count"] = t.Count()
+		values["min"] = t.Min()
+		values["max"] = t.Max()
+		values["mean"] = t.Mean()
+		values["stddev"] = t.StdDev()
+		values["median"] = ps[0]
+		values["75%"] = ps[1]
+		values["95%"] = ps[2]
+		values["99%"] = ps[3]
+		values["99.9%"] = ps[4]
+		values["1m.rate"] = t.Rate1()
+		values["5m.rate"] = t.Rate5()
+		values["15m.rate"] = t.Rate15()
+		values["mean.rate"] = t.RateMean()
+	}
+	return values
 }
 
+// GetOrRegister returns the metric with the given name, registering i under it if there isn't one. It's
+// subject to the registry's cardinality limits, so a new name may be redirected to an overflow name. If
+// a limit rejects the name, the registry's ErrorPolicy is applied, and ErrorPolicyLogAndNoop returns
+// the given metric without registering it
 func (registry *registryImpl) GetOrRegister(s string, i interface{}) interface{} {
-	return registry.metricMap.Upsert(s, wrapMetric(i), func(exist bool, valueInMap Metric, newValue Metric) Metric {
+	name, err := registry.applyCardinalityLimits(s)
+	if err != nil {
+		if registry.errorPolicy != ErrorPolicyLogAndNoop {
+			panic(err)
+		}
+		slog.Error("unable to register metric, returning it unregistered", "name", s, "error", err)
+		if v := reflect.ValueOf(i); v.Kind() == reflect.Func {
+			return v.Call(nil)[0].Interface()
+		}
+		return i
+	}
+	s = name
+
+	created := false
+	result := registry.metricMap.Upsert(s, nil, func(exist bool, valueInMap Metric, newValue Metric) Metric {
 		if exist {
 			return valueInMap
 		}
-		return newValue
+		if v := reflect.ValueOf(i); v.Kind() == reflect.Func {
+			i = v.Call(nil)[0].Interface()
+		}
+		created = true
+		return wrapMetric(i)
 	})
+	if created {
+		registry.metricAdded(s, result, 0)
+	}
+	return unwrapMetric(result)
 }
 
 func (registry *registryImpl) Register(s string, i interface{}) error {
-	if registry.metricMap.SetIfAbsent(s, wrapMetric(i)) {
-		return fmt.Errorf("duplicate metric %v", s)
+	if limit := registry.exceededCardinalityLimit(s); limit != nil {
+		return newCardinalityLimitError(s, limit)
+	}
+	metric := wrapMetric(i)
+	if !registry.metricMap.SetIfAbsent(s, metric) {
+		return metrics.DuplicateMetric(s)
 	}
+	registry.metricAdded(s, metric, 0)
 	return nil
 }
 
 func (registry *registryImpl) RunHealthchecks() {
+	registry.Each(func(name string, i interface{}) {
+		if healthcheck, ok := i.(metrics.Healthcheck); ok {
+			healthcheck.Check()
+		}
+	})
 }
 
+// Unregister removes the metric with the given name, regardless of any outstanding references to it,
+// and releases its resources
 func (registry *registryImpl) Unregister(s string) {
-	registry.metricMap.Remove(s)
+	var metric Metric
+	removed := registry.metricMap.RemoveCb(s, func(key string, v Metric, exists bool) bool {
+		if exists {
+			metric = v
+			markRemoved(v)
+		}
+		return exists
+	})
+	if removed {
+		registry.metricRemoved(s, metric)
+		stopMetric(metric)
+	}
 }
 
 func (registry *registryImpl) UnregisterAll() {
@@ -381,13 +1020,44 @@ func (registry *registryImpl) UnregisterAll() {
 	}
 }
 
+// markRemoved records that a reference counted metric has been removed, so it can no longer be acquired
+// through cached handles. It's called while the metric's entry is locked, so it's ordered with any
+// re-registration of an evicted metric
+func markRemoved(metric Metric) {
+	if rc, ok := metric.(refCounted); ok {
+		rc.markRemoved()
+	}
+}
+
+// stopMetric releases the resources held by a metric which has already been removed from the registry
+func stopMetric(metric Metric) {
+	switch m := metric.(type) {
+	case refCounted:
+		m.stop()
+	case *gaugeImpl, *gaugeFloat64Impl:
+		// no resources to cleanup
+	case *timerImpl:
+		m.stop()
+	case metricWrapper:
+		if stoppable, ok := m.value.(metrics.Stoppable); ok {
+			stoppable.Stop()
+		}
+	default:
+		m.Dispose()
+	}
+}
+
 func (registry *registryImpl) AcceptVisitor(visitor Visitor) {
+	registry.acceptVisitor(visitor, "")
+}
+
+func (registry *registryImpl) acceptVisitor(visitor Visitor, prefix string) {
 	// If there's nothing to report, skip it
 	if registry.metricMap.Count() == 0 {
 		return
 	}
 
-	registry.EachMetric(func(name string, i Metric) {
+	registry.eachMetricWithPrefix(prefix, func(name string, i Metric) {
 		switch metric := i.(type) {
 		case *gaugeImpl:
 			visitor.VisitGauge(name, metric)
@@ -399,6 +1069,10 @@ func (registry *registryImpl) AcceptVisitor(visitor Visitor) {
 			visitor.VisitHistogram(name, metric.CreateSnapshot())
 		case *timerImpl:
 			visitor.VisitTimer(name, metric.CreateSnapshot())
+		case metricWrapper:
+			visitGoMetric(name, metric.value, visitor)
+		case Visitable:
+			metric.AcceptVisitor(name, visitor)
 		default:
 			slog.Error("unsupported metric type", "type", reflect.TypeOf(i))
 		}
@@ -409,6 +1083,9 @@ type refCounted interface {
 	Metric
 	IncrRefCount() int32
 	DecrRefCount() int32
+	tryIncrRefCount() (int32, bool)
+	markRemoved()
+	refs() int32
 	Name() string
 	stop()
 }
@@ -0,0 +1,190 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests check that registryImpl behaves like a go-metrics StandardRegistry, so go-metrics
+// reporters work against it.
+
+func newCompatRegistry() metrics.Registry {
+	return NewRegistry("test", nil).(metrics.Registry)
+}
+
+func TestCompatRegister(t *testing.T) {
+	registry := newCompatRegistry()
+	counter := metrics.NewCounter()
+
+	require.NoError(t, registry.Register("counter", counter))
+	require.Equal(t, metrics.DuplicateMetric("counter"), registry.Register("counter", metrics.NewCounter()))
+	require.Same(t, counter, registry.Get("counter"))
+	require.Nil(t, registry.Get("missing"))
+}
+
+func TestCompatGetOrRegister(t *testing.T) {
+	registry := newCompatRegistry()
+
+	counter := registry.GetOrRegister("counter", metrics.NewCounter)
+	require.IsType(t, &metrics.StandardCounter{}, counter)
+	require.Same(t, counter, registry.GetOrRegister("counter", metrics.NewCounter()))
+
+	meter := metrics.GetOrRegisterMeter("meter", registry)
+	defer meter.Stop()
+	require.Same(t, meter, metrics.GetOrRegisterMeter("meter", registry))
+}
+
+func TestCompatEachUnwraps(t *testing.T) {
+	registry := newCompatRegistry()
+	counter := metrics.GetOrRegisterCounter("counter", registry)
+
+	found := map[string]interface{}{}
+	registry.Each(func(name string, i interface{}) {
+		found[name] = i
+	})
+	require.Same(t, counter, found["counter"])
+}
+
+func TestCompatGetAll(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Gauge("gauge").Update(7)
+	registry.Meter("meter").Mark(3)
+	registry.Histogram("histogram").Update(5)
+	registry.Timer("timer").Update(time.Second)
+	metrics.GetOrRegisterCounter("counter", registry.(metrics.Registry)).Inc(2)
+
+	all := registry.(metrics.Registry).GetAll()
+	require.Equal(t, int64(7), all["gauge"]["value"])
+	require.Equal(t, int64(3), all["meter"]["count"])
+	require.Equal(t, int64(5), all["histogram"]["max"])
+	require.Equal(t, int64(time.Second), all["timer"]["max"])
+	require.Equal(t, int64(2), all["counter"]["count"])
+}
+
+func TestCompatRunHealthchecks(t *testing.T) {
+	registry := newCompatRegistry()
+	checks := 0
+	healthcheck := metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		checks++
+		h.Unhealthy(errors.New("down"))
+	})
+	require.NoError(t, registry.Register("health", healthcheck))
+
+	registry.RunHealthchecks()
+	require.Equal(t, 1, checks)
+	require.EqualError(t, healthcheck.Error(), "down")
+	require.Equal(t, "down", registry.GetAll()["health"]["error"])
+}
+
+func TestCompatUnregisterDisposes(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	meter := registry.Meter("meter")
+	registry.Meter("meter")
+
+	registry.(metrics.Registry).Unregister("meter")
+	require.False(t, registry.IsValidMetric("meter"))
+
+	// meter is stopped, so further marks are ignored
+	meter.Mark(1)
+	require.Equal(t, int64(0), meter.Count())
+
+	stoppable := metrics.NewMeter()
+	require.NoError(t, registry.(metrics.Registry).Register("foreign", stoppable))
+	registry.(metrics.Registry).UnregisterAll()
+	stoppable.Mark(1)
+	require.Equal(t, int64(0), stoppable.Count())
+}
+
+func TestCompatReporters(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Gauge("gauge").Update(7)
+	registry.Meter("meter").Mark(3)
+	registry.Timer("timer").Update(time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	metrics.WriteJSONOnce(registry.(metrics.Registry), buf)
+	values := map[string]map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &values))
+	require.Equal(t, float64(7), values["gauge"]["value"])
+	require.Equal(t, float64(3), values["meter"]["count"])
+
+	buf.Reset()
+	metrics.WriteOnce(registry.(metrics.Registry), buf)
+	require.Contains(t, buf.String(), "gauge gauge")
+	require.Contains(t, buf.String(), "meter meter")
+	require.Contains(t, buf.String(), "timer timer")
+
+	logger := &bufferLogger{}
+	cue := make(chan interface{}, 1)
+	cue <- struct{}{}
+	close(cue)
+	metrics.LogOnCue(registry.(metrics.Registry), cue, logger)
+	require.Contains(t, logger.String(), "gauge gauge")
+	require.Contains(t, logger.String(), "timer timer")
+}
+
+type bufferLogger struct {
+	strings.Builder
+}
+
+func (self *bufferLogger) Printf(format string, v ...interface{}) {
+	self.WriteString(fmt.Sprintf(format, v...))
+	self.WriteString("\n")
+}
+
+func TestCompatVisitGoMetrics(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	goRegistry := registry.(metrics.Registry)
+
+	metrics.GetOrRegisterCounter("counter", goRegistry).Inc(4)
+	metrics.GetOrRegisterGauge("gauge", goRegistry).Update(5)
+	metrics.GetOrRegisterGaugeFloat64("floatGauge", goRegistry).Update(1.5)
+	meter := metrics.GetOrRegisterMeter("meter", goRegistry)
+	defer meter.Stop()
+	meter.Mark(2)
+	metrics.GetOrRegisterHistogram("histogram", goRegistry, metrics.NewUniformSample(10)).Update(3)
+	timer := metrics.GetOrRegisterTimer("timer", goRegistry)
+	defer timer.Stop()
+	timer.Update(time.Second)
+	healthcheck := metrics.NewHealthcheck(func(h metrics.Healthcheck) {})
+	require.NoError(t, goRegistry.Register("health", healthcheck))
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+
+	require.Equal(t, int64(4), visitor.gauges["counter"].Value())
+	require.Equal(t, int64(5), visitor.gauges["gauge"].Value())
+	require.Equal(t, 1.5, visitor.floatGauge["floatGauge"].Value())
+	require.Equal(t, int64(2), visitor.meters["meter"].Count())
+	require.Equal(t, int64(3), visitor.histograms["histogram"].Max())
+	require.Equal(t, int64(time.Second), visitor.timers["timer"].Max())
+	require.Equal(t, int64(1), visitor.gauges["health"].Value())
+
+	healthcheck.Unhealthy(errors.New("down"))
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, int64(0), visitor.gauges["health"].Value())
+}
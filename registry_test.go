@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/require"
 )
 
@@ -80,3 +81,71 @@ func TestAcceptVisitorWalksAllTypes(t *testing.T) {
 	require.Contains(t, visitor.histograms, "histogram")
 	require.Contains(t, visitor.timers, "timer")
 }
+
+func TestTypeConflict(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Histogram("metric")
+
+	_, err := registry.TryMeter("metric")
+	require.ErrorIs(t, err, ErrMetricTypeConflict)
+
+	var conflictErr *MetricTypeConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	require.Equal(t, "metric", conflictErr.Name)
+	require.Equal(t, MetricTypeHistogram, conflictErr.Existing)
+	require.Equal(t, MetricTypeMeter, conflictErr.Requested)
+
+	_, err = registry.TryGauge("metric")
+	require.ErrorIs(t, err, ErrMetricTypeConflict)
+
+	require.Panics(t, func() {
+		registry.Timer("metric")
+	})
+
+	// the failed attempts must not have taken references on the histogram
+	registry.GetHistogram("metric").Dispose()
+	require.False(t, registry.IsValidMetric("metric"))
+}
+
+func TestTypeConflictNoopPolicy(t *testing.T) {
+	registry := NewRegistry("test", nil, WithErrorPolicy(ErrorPolicyLogAndNoop))
+	registry.Gauge("metric").Update(5)
+
+	meter := registry.Meter("metric")
+	require.NotNil(t, meter)
+	meter.Mark(1)
+	meter.Dispose()
+
+	require.Equal(t, int64(5), registry.GetGauge("metric").Value())
+	require.Nil(t, registry.GetMeter("metric"))
+}
+
+// stringSet is a custom metric kind, counting distinct values, used to test Visitable
+type stringSet struct {
+	values map[string]struct{}
+}
+
+func (s *stringSet) Dispose() {}
+
+func (s *stringSet) AcceptVisitor(name string, visitor Visitor) {
+	gauge := &gaugeImpl{Gauge: metrics.NewGauge()}
+	gauge.Update(int64(len(s.values)))
+	visitor.VisitGauge(name+".size", gauge)
+}
+
+func TestAcceptVisitorVisitable(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	set := &stringSet{values: map[string]struct{}{"a": {}, "b": {}}}
+	require.NoError(t, registry.Register("identities", set))
+	require.Error(t, registry.Register("identities", set))
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, int64(2), visitor.gauges["identities.size"].Value())
+
+	sink := report(registry)
+	require.Equal(t, int64(2), sink.ints["identities.size"])
+
+	registry.Unregister("identities")
+	require.False(t, registry.IsValidMetric("identities"))
+}
@@ -1,7 +1,10 @@
 package metrics
 
 import (
-	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -15,23 +18,130 @@ type MetricSink interface {
 	AcceptPercentileMetric(name string, value PercentileSource)
 }
 
+// UnitMetricSink may be implemented by a MetricSink which wants to know the unit of each value it is
+// given. If a sink implements it, the DelegatingReporter calls these methods instead of the unit-less
+// ones. Values which have no unit are reported with UnitNone.
+type UnitMetricSink interface {
+	AcceptIntMetricWithUnit(name string, value int64, unit Unit)
+	AcceptFloatMetricWithUnit(name string, value float64, unit Unit)
+	AcceptPercentileMetricWithUnit(name string, value PercentileSource, unit Unit)
+}
+
+// ReaderMetricSink may be implemented by a MetricSink which wants to be given the RegistryReader being
+// reported, for example when reporting a MultiRegistry, which isn't a Registry. If a sink implements it,
+// the DelegatingReporter calls these methods instead of StartReport and EndReport. Sinks which implement
+// neither are given a report for each Registry a MultiRegistry is made of, rather than a nil Registry.
+type ReaderMetricSink interface {
+	StartReaderReport(registry RegistryReader)
+	EndReaderReport(registry RegistryReader)
+}
+
 type PercentileSource interface {
 	Percentile(float64) float64
 }
 
-func NewDelegatingReporter(registry Registry, sink MetricSink, closeNotify <-chan struct{}) *DelegatingReporter {
-	return &DelegatingReporter{
+type scaledPercentileSource struct {
+	source PercentileSource
+	factor float64
+}
+
+func (self *scaledPercentileSource) Percentile(p float64) float64 {
+	return self.source.Percentile(p) * self.factor
+}
+
+// PercentileNamer builds the name a single percentile of a histogram or timer is reported under
+type PercentileNamer func(name string, percentile float64) string
+
+// PercentileNameShort names percentiles using a short suffix, for example name.p05, name.p50, name.p99 and
+// name.p999. The suffix is the percentage with its decimal point removed, and with its whole part padded to
+// two digits, so that p005 is the 0.5th percentile and p05 the 5th. The 100th percentile is p100
+func PercentileNameShort(name string, percentile float64) string {
+	// round to ten significant digits, so that for example 0.999 is named p999 rather than p9989999999999999
+	percent, _ := strconv.ParseFloat(strconv.FormatFloat(percentile*100, 'g', 10, 64), 64)
+	whole, fraction, _ := strings.Cut(strconv.FormatFloat(percent, 'f', -1, 64), ".")
+	if len(whole) < 2 {
+		whole = "0" + whole
+	}
+	return name + ".p" + whole + fraction
+}
+
+// PercentileNameQuantile names percentiles using a quantile label, for example name{quantile="0.99"}
+func PercentileNameQuantile(name string, percentile float64) string {
+	return name + `{quantile="` + strconv.FormatFloat(percentile, 'f', -1, 64) + `"}`
+}
+
+// WithUnitConversion configures the reporter to convert the values of metrics recorded in a unit of the
+// same kind as the target unit into the target unit. For example, WithUnitConversion(UnitMilliseconds)
+// reports timers in milliseconds instead of nanoseconds. Converted values are reported as floats, while
+// values already in the target unit are reported as they are. Counts and rates are never converted.
+func WithUnitConversion(target Unit) DelegatingReporterOption {
+	return func(reporter *DelegatingReporter) {
+		reporter.unitTargets = append(reporter.unitTargets, target)
+	}
+}
+
+// DelegatingReporterOption configures optional behavior of a DelegatingReporter
+type DelegatingReporterOption func(reporter *DelegatingReporter)
+
+// WithPercentiles configures the reporter to emit one float metric per given percentile for histograms and
+// timers, named using the given namer. If namer is nil, PercentileNameShort is used. When percentiles are
+// configured the raw PercentileSource is no longer passed to the sink, unless WithRawPercentiles is also given.
+func WithPercentiles(namer PercentileNamer, percentiles ...float64) DelegatingReporterOption {
+	return func(reporter *DelegatingReporter) {
+		if namer == nil {
+			namer = PercentileNameShort
+		}
+		reporter.percentileNamer = namer
+		reporter.percentiles = percentiles
+	}
+}
+
+// WithOutcomeLabels configures the reporter to name the outcomes of operations using a label, for example
+// name.count{outcome="success"}, rather than a suffix, for example name.success.count
+func WithOutcomeLabels() DelegatingReporterOption {
+	return func(reporter *DelegatingReporter) {
+		reporter.outcomeLabels = true
+	}
+}
+
+// WithRawPercentiles configures the reporter to always pass the raw PercentileSource to the sink
+func WithRawPercentiles() DelegatingReporterOption {
+	return func(reporter *DelegatingReporter) {
+		reporter.rawPercentiles = true
+	}
+}
+
+func NewDelegatingReporter(registry RegistryReader, sink MetricSink, closeNotify <-chan struct{}, options ...DelegatingReporterOption) *DelegatingReporter {
+	result := &DelegatingReporter{
 		registry:    registry,
 		closeNotify: closeNotify,
 		sink:        sink,
+		fields:      map[MetricType]fieldSet{},
+		names:       newReportedNameCache(),
+	}
+	for metricType, fields := range defaultMetricFields {
+		result.fields[metricType] = newFieldSet(fields)
+	}
+	for _, option := range options {
+		option(result)
 	}
+	result.unitSink, _ = sink.(UnitMetricSink)
+	return result
 }
 
 type DelegatingReporter struct {
-	registry    Registry
-	closeNotify <-chan struct{}
-	sink        MetricSink
-	started     atomic.Bool
+	registry        RegistryReader
+	closeNotify     <-chan struct{}
+	sink            MetricSink
+	started         atomic.Bool
+	percentiles     []float64
+	percentileNamer PercentileNamer
+	rawPercentiles  bool
+	outcomeLabels   bool
+	fields          map[MetricType]fieldSet
+	unitTargets     []Unit
+	unitSink        UnitMetricSink
+	names           reportedNameCache
 }
 
 func (self *DelegatingReporter) Start(interval time.Duration) {
@@ -45,86 +155,521 @@ func (self *DelegatingReporter) Start(interval time.Duration) {
 	for {
 		select {
 		case <-timer.C:
-			self.sink.StartReport(self.registry)
-			self.registry.AcceptVisitor(self)
-			self.sink.EndReport(self.registry)
+			self.Report()
 		case <-self.closeNotify:
 			return
 		}
 	}
 }
 
-func (self *DelegatingReporter) VisitIntMetric(name string, val int64, extra string) {
-	if len(extra) > 0 {
-		name = fmt.Sprintf("%s.%s", name, extra)
+// Report runs a single report of the registry to the sink
+func (self *DelegatingReporter) Report() {
+	self.startReport()
+	if metadataSink, ok := self.sink.(MetadataMetricSink); ok {
+		self.registry.Describe(metadataSinkDescriber{sink: metadataSink})
 	}
-	if self.sink.Filter(name) {
-		self.sink.AcceptIntMetric(name, val)
+	self.registry.AcceptVisitor(self)
+	self.endReport()
+	self.names.endReport()
+}
+
+// startReport starts the report with the sink. A sink which only takes a Registry isn't started here when
+// the reported registry isn't one, such as a MultiRegistry, as it's started for each of its sources instead
+func (self *DelegatingReporter) startReport() {
+	if readerSink, ok := self.sink.(ReaderMetricSink); ok {
+		readerSink.StartReaderReport(self.registry)
+	} else if registry, ok := self.registry.(Registry); ok {
+		self.sink.StartReport(registry)
 	}
 }
 
-func (self *DelegatingReporter) VisitFloatMetric(name string, val float64, extra string) {
-	if len(extra) > 0 {
-		name = fmt.Sprintf("%s.%s", name, extra)
+func (self *DelegatingReporter) endReport() {
+	if readerSink, ok := self.sink.(ReaderMetricSink); ok {
+		readerSink.EndReaderReport(self.registry)
+	} else if registry, ok := self.registry.(Registry); ok {
+		self.sink.EndReport(registry)
 	}
-	if self.sink.Filter(name) {
-		self.sink.AcceptFloatMetric(name, val)
+}
+
+// StartSource implements SourceVisitor, passing the call on to sinks which implement it. Sinks which only
+// take a Registry are given a report for each source which is a Registry, when the reported registry isn't
+// one itself, so their metrics are still reported along with the registry they come from
+func (self *DelegatingReporter) StartSource(source RegistryReader) {
+	if sourceSink, ok := self.sink.(SourceVisitor); ok {
+		sourceSink.StartSource(source)
+	} else if registry, ok := self.sourceReport(source); ok {
+		self.sink.StartReport(registry)
+	}
+}
+
+// EndSource implements SourceVisitor, ending what StartSource started
+func (self *DelegatingReporter) EndSource(source RegistryReader) {
+	if sourceSink, ok := self.sink.(SourceVisitor); ok {
+		sourceSink.EndSource(source)
+	} else if registry, ok := self.sourceReport(source); ok {
+		self.sink.EndReport(registry)
+	}
+}
+
+// sourceReport returns the Registry to report the given source as, if the sink is given a report per source
+func (self *DelegatingReporter) sourceReport(source RegistryReader) (Registry, bool) {
+	if _, ok := self.sink.(ReaderMetricSink); ok {
+		return nil, false
+	}
+	if _, ok := self.registry.(Registry); ok {
+		return nil, false
+	}
+	registry, ok := source.(Registry)
+	return registry, ok
+}
+
+// reportedName identifies a name built from a metric name, so it only has to be built once, rather
+// than on every report
+type reportedName struct {
+	kind       reportedNameKind
+	name       string
+	extra      string
+	outcome    string
+	window     time.Duration
+	percentile float64
+}
+
+type reportedNameKind uint8
+
+const (
+	reportedNameSuffixed reportedNameKind = iota
+	reportedNameRateWindow
+	reportedNamePercentile
+	reportedNameOutcome
+)
+
+// maxReportedNames bounds the names built since the cache was last rotated. Reports rotate the cache, but
+// a reporter used as a plain Visitor never reports, so the cache also rotates when it reaches this size
+const maxReportedNames = 1 << 16
+
+// reportedNameCache keeps the names built during the current and the previous report. Names which weren't
+// used during a whole report are dropped, so the cache doesn't grow as metrics come and go.
+type reportedNameCache struct {
+	sync.Mutex
+	current  map[reportedName]string
+	previous map[reportedName]string
+}
+
+func newReportedNameCache() reportedNameCache {
+	return reportedNameCache{
+		current:  map[reportedName]string{},
+		previous: map[reportedName]string{},
+	}
+}
+
+func (self *reportedNameCache) get(key reportedName, build func(key reportedName) string) string {
+	self.Lock()
+	defer self.Unlock()
+
+	result, found := self.current[key]
+	if !found {
+		if result, found = self.previous[key]; !found {
+			result = build(key)
+		}
+		if len(self.current) >= maxReportedNames {
+			self.rotate()
+		}
+		self.current[key] = result
+	}
+	return result
+}
+
+func (self *reportedNameCache) endReport() {
+	self.Lock()
+	defer self.Unlock()
+	self.rotate()
+}
+
+func (self *reportedNameCache) rotate() {
+	self.previous, self.current = self.current, self.previous
+	clear(self.current)
+}
+
+func buildSuffixedName(key reportedName) string {
+	return key.name + "." + key.extra
+}
+
+func buildRateWindowName(key reportedName) string {
+	return key.name + "." + RateWindowName(key.window)
+}
+
+// suffixed returns name.extra
+func (self *DelegatingReporter) suffixed(name string, extra string) string {
+	if len(extra) == 0 {
+		return name
+	}
+	return self.names.get(reportedName{kind: reportedNameSuffixed, name: name, extra: extra}, buildSuffixedName)
+}
+
+// rateWindowName returns the name the rate of the given metric over the given window is reported under
+func (self *DelegatingReporter) rateWindowName(name string, window time.Duration) string {
+	return self.names.get(reportedName{kind: reportedNameRateWindow, name: name, window: window}, buildRateWindowName)
+}
+
+// percentileName returns the name the given percentile of the given metric is reported under
+func (self *DelegatingReporter) percentileName(name string, percentile float64) string {
+	return self.names.get(reportedName{kind: reportedNamePercentile, name: name, percentile: percentile}, self.buildPercentileName)
+}
+
+func (self *DelegatingReporter) buildPercentileName(key reportedName) string {
+	return self.percentileNamer(key.name, key.percentile)
+}
+
+// meterFieldName returns the name a field of a meter is reported under. The field is either given by name,
+// or is the rate over the given window. If outcome isn't empty, the meter counts that outcome of an operation
+func (self *DelegatingReporter) meterFieldName(name string, outcome string, field string, window time.Duration) string {
+	if outcome == "" {
+		if window != 0 {
+			return self.rateWindowName(name, window)
+		}
+		return self.suffixed(name, field)
 	}
+	key := reportedName{kind: reportedNameOutcome, name: name, extra: field, outcome: outcome, window: window}
+	return self.names.get(key, self.buildOutcomeName)
+}
+
+func (self *DelegatingReporter) buildOutcomeName(key reportedName) string {
+	field := key.extra
+	if key.window != 0 {
+		field = RateWindowName(key.window)
+	}
+	if self.outcomeLabels {
+		return key.name + "." + field + `{outcome="` + key.outcome + `"}`
+	}
+	return key.name + "." + key.outcome + "." + field
+}
+
+type metadataSinkDescriber struct {
+	sink MetadataMetricSink
+}
+
+func (self metadataSinkDescriber) VisitMetadata(name string, metadata Metadata) {
+	self.sink.AcceptMetadata(name, metadata)
+}
+
+func (self metadataSinkDescriber) StartSource(source RegistryReader) {
+	if sourceSink, ok := self.sink.(SourceVisitor); ok {
+		sourceSink.StartSource(source)
+	}
+}
+
+func (self metadataSinkDescriber) EndSource(source RegistryReader) {
+	if sourceSink, ok := self.sink.(SourceVisitor); ok {
+		sourceSink.EndSource(source)
+	}
+}
+
+func (self *DelegatingReporter) VisitIntMetric(name string, val int64, extra string) {
+	self.visitInt(name, val, extra, UnitNone)
+}
+
+func (self *DelegatingReporter) VisitFloatMetric(name string, val float64, extra string) {
+	self.visitFloat(name, val, extra, UnitNone)
 }
 
 func (self *DelegatingReporter) VisitPercentileMetric(name string, val PercentileSource, extra string) {
-	if len(extra) > 0 {
-		name = fmt.Sprintf("%s.%s", name, extra)
+	self.visitPercentile(name, val, extra, UnitNone)
+}
+
+func (self *DelegatingReporter) visitInt(name string, val int64, extra string, unit Unit) {
+	name = self.suffixed(name, extra)
+	if self.sink.Filter(name) {
+		if self.unitSink != nil {
+			self.unitSink.AcceptIntMetricWithUnit(name, val, unit)
+		} else {
+			self.sink.AcceptIntMetric(name, val)
+		}
 	}
+}
+
+func (self *DelegatingReporter) visitFloat(name string, val float64, extra string, unit Unit) {
+	name = self.suffixed(name, extra)
+	if self.sink.Filter(name) {
+		if self.unitSink != nil {
+			self.unitSink.AcceptFloatMetricWithUnit(name, val, unit)
+		} else {
+			self.sink.AcceptFloatMetric(name, val)
+		}
+	}
+}
+
+func (self *DelegatingReporter) visitPercentile(name string, val PercentileSource, extra string, unit Unit) {
+	name = self.suffixed(name, extra)
 	if self.sink.Filter(name) {
-		self.sink.AcceptPercentileMetric(name, val)
+		if self.unitSink != nil {
+			self.unitSink.AcceptPercentileMetricWithUnit(name, val, unit)
+		} else {
+			self.sink.AcceptPercentileMetric(name, val)
+		}
+	}
+}
+
+// convert returns the unit values in the given unit should be reported in, along with the factor
+// needed to convert them, if a matching unit conversion has been configured. Values already in the
+// target unit aren't converted
+func (self *DelegatingReporter) convert(unit Unit) (Unit, float64, bool) {
+	for _, target := range self.unitTargets {
+		if factor, ok := unit.ConversionFactor(target); ok {
+			return target, factor, target != unit
+		}
 	}
+	return unit, 1, false
+}
+
+func (self *DelegatingReporter) visitValue(name string, val int64, extra string, unit Unit) {
+	if target, factor, ok := self.convert(unit); ok {
+		self.visitFloat(name, float64(val)*factor, extra, target)
+	} else {
+		self.visitInt(name, val, extra, unit)
+	}
+}
+
+func (self *DelegatingReporter) visitFloatValue(name string, val float64, extra string, unit Unit) {
+	target, factor, _ := self.convert(unit)
+	self.visitFloat(name, val*factor, extra, target)
+}
+
+func (self *DelegatingReporter) visitVariance(name string, val float64, unit Unit) {
+	target, factor, _ := self.convert(unit)
+	self.visitFloat(name, val*factor*factor, MetricNameVariance, target.Squared())
+}
+
+// VisitPercentiles reports the configured percentiles of the given source as individual float metrics and,
+// if no percentiles are configured or raw percentiles were requested, the source itself
+func (self *DelegatingReporter) VisitPercentiles(name string, val PercentileSource) {
+	self.visitPercentiles(name, val, UnitNone)
+}
+
+func (self *DelegatingReporter) visitPercentiles(name string, val PercentileSource, unit Unit) {
+	target, factor, converted := self.convert(unit)
+
+	if len(self.percentiles) > 0 {
+		values := self.percentileValues(val)
+		for i, p := range self.percentiles {
+			self.visitFloat(self.percentileName(name, p), values[i]*factor, "", target)
+		}
+	}
+
+	if len(self.percentiles) == 0 || self.rawPercentiles {
+		if converted {
+			val = &scaledPercentileSource{source: val, factor: factor}
+		}
+		self.visitPercentile(name, val, MetricNamePercentile, target)
+	}
+}
+
+func (self *DelegatingReporter) percentileValues(val PercentileSource) []float64 {
+	if multi, ok := val.(interface{ Percentiles([]float64) []float64 }); ok {
+		return multi.Percentiles(self.percentiles)
+	}
+	values := make([]float64, len(self.percentiles))
+	for i, p := range self.percentiles {
+		values[i] = val.Percentile(p)
+	}
+	return values
 }
 
 const (
-	MetricNameCount      = "count"
-	MetricNameMean       = "mean"
-	MetricNameRateM1     = "rate_m1"
-	MetricNameRateM5     = "rate_m5"
-	MetricNameRateM15    = "rate_m15"
-	MetricNameMin        = "min"
-	MetricNameMax        = "max"
-	MetricNamePercentile = "percentile"
+	MetricNameCount    = "count"
+	MetricNameMean     = "mean"
+	MetricNameRateM1   = "rate_m1"
+	MetricNameRateM5   = "rate_m5"
+	MetricNameRateM15  = "rate_m15"
+	MetricNameRateMean = "rate_mean"
+	// MetricNameRateWindows selects the rates over the custom windows a meter or timer was created with.
+	// Each is reported under the name given by RateWindowName, for example rate_10s
+	MetricNameRateWindows = "rate_windows"
+	MetricNameMin         = "min"
+	MetricNameMax         = "max"
+	MetricNameStdDev      = "std_dev"
+	MetricNameVariance    = "variance"
+	MetricNameSum         = "sum"
+	MetricNamePercentile  = "percentile"
 )
 
+// defaultMetricFields lists the statistics reported for each metric type when no field selection has
+// been configured using WithMetricFields
+var defaultMetricFields = map[MetricType][]string{
+	MetricTypeMeter: {
+		MetricNameCount, MetricNameRateM1, MetricNameRateM5, MetricNameRateM15, MetricNameRateMean,
+		MetricNameRateWindows,
+	},
+	MetricTypeHistogram: {
+		MetricNameCount, MetricNameMean, MetricNameMin, MetricNameMax, MetricNamePercentile,
+	},
+	MetricTypeWindowCounter: {
+		MetricNameCount, MetricNameRateWindows,
+	},
+	MetricTypeTimer: {
+		MetricNameCount, MetricNameRateM1, MetricNameRateM5, MetricNameRateM15, MetricNameRateWindows,
+		MetricNameMean, MetricNameMin, MetricNameMax, MetricNamePercentile,
+	},
+}
+
+// DefaultMetricFields returns the statistics reported for each metric type when no field selection has
+// been configured using WithMetricFields. Meters and timers report their mean rate under MetricNameRateMean,
+// and timers and histograms their mean value under MetricNameMean. The result is a copy, so it can be
+// modified, for example to build a selection from the defaults.
+func DefaultMetricFields() map[MetricType][]string {
+	result := make(map[MetricType][]string, len(defaultMetricFields))
+	for metricType, fields := range defaultMetricFields {
+		result[metricType] = slices.Clone(fields)
+	}
+	return result
+}
+
+// WithMetricFields selects which statistics are reported for the given metric type, replacing the
+// defaults from DefaultMetricFields. Fields are named using the MetricName constants.
+func WithMetricFields(metricType MetricType, fields ...string) DelegatingReporterOption {
+	return func(reporter *DelegatingReporter) {
+		reporter.fields[metricType] = newFieldSet(fields)
+	}
+}
+
+type fieldSet map[string]struct{}
+
+func newFieldSet(fields []string) fieldSet {
+	result := fieldSet{}
+	for _, field := range fields {
+		result[field] = struct{}{}
+	}
+	return result
+}
+
+func (self *DelegatingReporter) reports(metricType MetricType, field string) bool {
+	_, found := self.fields[metricType][field]
+	return found
+}
+
 func (self *DelegatingReporter) VisitGauge(name string, gauge Gauge) {
-	self.VisitIntMetric(name, gauge.Value(), "")
+	self.visitValue(name, gauge.Value(), "", UnitOf(gauge))
 }
 
 func (self *DelegatingReporter) VisitGaugeFloat64(name string, gauge GaugeFloat64) {
-	self.VisitFloatMetric(name, gauge.Value(), "")
+	self.visitFloatValue(name, gauge.Value(), "", UnitOf(gauge))
 }
 
 func (self *DelegatingReporter) VisitMeter(name string, metric Meter) {
-	self.VisitIntMetric(name, metric.Count(), MetricNameCount)
-	self.VisitFloatMetric(name, metric.Rate1(), MetricNameRateM1)
-	self.VisitFloatMetric(name, metric.Rate5(), MetricNameRateM5)
-	self.VisitFloatMetric(name, metric.Rate15(), MetricNameRateM15)
-	self.VisitFloatMetric(name, metric.RateMean(), MetricNameMean)
+	self.visitMeter(name, "", metric)
+}
+
+// visitMeter reports a meter. If outcome isn't empty, the meter counts an outcome of the operation with the
+// given name, and its values are named accordingly
+func (self *DelegatingReporter) visitMeter(name string, outcome string, metric Meter) {
+	self.visitRates(MetricTypeMeter, name, outcome, metric, UnitOf(metric))
+}
+
+// ratedMetric is a meter or a timer, which both count events and track their rates
+type ratedMetric interface {
+	Metric
+	rateFieldSource
+}
+
+// visitRates reports the count and rates of a meter or timer, as selected for the given metric type. unit
+// is the unit of what's counted, so the rates are in unit.PerSecond(). If outcome isn't empty, the metric
+// counts an outcome of the operation with the given name, and its values are named accordingly
+func (self *DelegatingReporter) visitRates(metricType MetricType, name string, outcome string, metric ratedMetric, unit Unit) {
+	if self.reports(metricType, MetricNameCount) {
+		self.visitInt(self.meterFieldName(name, outcome, MetricNameCount, 0), metric.Count(), "", unit)
+	}
+	rateUnit := unit.PerSecond()
+	if self.reports(metricType, MetricNameRateM1) {
+		self.visitFloat(self.meterFieldName(name, outcome, MetricNameRateM1, 0), metric.Rate1(), "", rateUnit)
+	}
+	if self.reports(metricType, MetricNameRateM5) {
+		self.visitFloat(self.meterFieldName(name, outcome, MetricNameRateM5, 0), metric.Rate5(), "", rateUnit)
+	}
+	if self.reports(metricType, MetricNameRateM15) {
+		self.visitFloat(self.meterFieldName(name, outcome, MetricNameRateM15, 0), metric.Rate15(), "", rateUnit)
+	}
+	if self.reports(metricType, MetricNameRateMean) {
+		self.visitFloat(self.meterFieldName(name, outcome, MetricNameRateMean, 0), metric.RateMean(), "", rateUnit)
+	}
+	if self.reports(metricType, MetricNameRateWindows) {
+		for _, window := range RateWindows(metric) {
+			self.visitFloat(self.meterFieldName(name, outcome, "", window), Rate(metric, window), "", rateUnit)
+		}
+	}
+}
+
+// VisitOperation reports the timer of an operation under the operation's name, and the meter of each
+// outcome as <name>.<outcome>.<field>, or as <name>.<field>{outcome="<outcome>"} if WithOutcomeLabels was given
+func (self *DelegatingReporter) VisitOperation(name string, operation Operation) {
+	self.VisitTimer(name, operation.Timer())
+	for _, outcome := range operation.Outcomes() {
+		self.visitMeter(name, outcome, operation.Outcome(outcome))
+	}
+}
+
+// VisitWindowCounter reports the total of a window counter as <name>.count, and its rate under the name
+// given by RateWindowName for its window, for example <name>.rate_1m
+func (self *DelegatingReporter) VisitWindowCounter(name string, metric WindowCounter) {
+	unit := UnitOf(metric)
+	if self.reports(MetricTypeWindowCounter, MetricNameCount) {
+		self.visitInt(name, metric.Total(), MetricNameCount, unit)
+	}
+	if self.reports(MetricTypeWindowCounter, MetricNameRateWindows) {
+		self.visitFloat(self.rateWindowName(name, metric.Window()), metric.Rate(), "", unit.PerSecond())
+	}
 }
 
 func (self *DelegatingReporter) VisitHistogram(name string, metric Histogram) {
-	self.VisitIntMetric(name, metric.Count(), MetricNameCount)
-	self.VisitFloatMetric(name, metric.Mean(), MetricNameMean)
-	self.VisitIntMetric(name, metric.Min(), MetricNameMin)
-	self.VisitIntMetric(name, metric.Max(), MetricNameMax)
-	self.VisitPercentileMetric(name, metric, MetricNamePercentile)
+	unit := UnitOf(metric)
+	if self.reports(MetricTypeHistogram, MetricNameCount) {
+		self.VisitIntMetric(name, metric.Count(), MetricNameCount)
+	}
+	if self.reports(MetricTypeHistogram, MetricNameMean) {
+		self.visitFloatValue(name, metric.Mean(), MetricNameMean, unit)
+	}
+	if self.reports(MetricTypeHistogram, MetricNameMin) {
+		self.visitValue(name, metric.Min(), MetricNameMin, unit)
+	}
+	if self.reports(MetricTypeHistogram, MetricNameMax) {
+		self.visitValue(name, metric.Max(), MetricNameMax, unit)
+	}
+	if self.reports(MetricTypeHistogram, MetricNameStdDev) {
+		self.visitFloatValue(name, metric.StdDev(), MetricNameStdDev, unit)
+	}
+	if self.reports(MetricTypeHistogram, MetricNameVariance) {
+		self.visitVariance(name, metric.Variance(), unit)
+	}
+	if self.reports(MetricTypeHistogram, MetricNameSum) {
+		self.visitValue(name, metric.Sum(), MetricNameSum, unit)
+	}
+	if self.reports(MetricTypeHistogram, MetricNamePercentile) {
+		self.visitPercentiles(name, metric, unit)
+	}
 }
 
 func (self *DelegatingReporter) VisitTimer(name string, metric Timer) {
-	self.VisitIntMetric(name, metric.Count(), MetricNameCount)
+	// timers count invocations, which have no unit, like the events counted by a meter without one
+	self.visitRates(MetricTypeTimer, name, "", metric, UnitNone)
 
-	self.VisitFloatMetric(name, metric.Rate1(), MetricNameRateM1)
-	self.VisitFloatMetric(name, metric.Rate5(), MetricNameRateM5)
-	self.VisitFloatMetric(name, metric.Rate15(), MetricNameRateM15)
-
-	self.VisitFloatMetric(name, metric.Mean(), MetricNameMean)
-	self.VisitIntMetric(name, metric.Min(), MetricNameMin)
-	self.VisitIntMetric(name, metric.Max(), MetricNameMax)
-	self.VisitPercentileMetric(name, metric, MetricNamePercentile)
+	unit := UnitOf(metric)
+	if self.reports(MetricTypeTimer, MetricNameMean) {
+		self.visitFloatValue(name, metric.Mean(), MetricNameMean, unit)
+	}
+	if self.reports(MetricTypeTimer, MetricNameMin) {
+		self.visitValue(name, metric.Min(), MetricNameMin, unit)
+	}
+	if self.reports(MetricTypeTimer, MetricNameMax) {
+		self.visitValue(name, metric.Max(), MetricNameMax, unit)
+	}
+	if self.reports(MetricTypeTimer, MetricNameStdDev) {
+		self.visitFloatValue(name, metric.StdDev(), MetricNameStdDev, unit)
+	}
+	if self.reports(MetricTypeTimer, MetricNameVariance) {
+		self.visitVariance(name, metric.Variance(), unit)
+	}
+	if self.reports(MetricTypeTimer, MetricNameSum) {
+		self.visitValue(name, metric.Sum(), MetricNameSum, unit)
+	}
+	if self.reports(MetricTypeTimer, MetricNamePercentile) {
+		self.visitPercentiles(name, metric, unit)
+	}
 }
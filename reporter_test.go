@@ -0,0 +1,200 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// collectingSink records everything a DelegatingReporter hands it, so tests can
+// assert on the names and values produced for each metric type.
+type collectingSink struct {
+	ints        map[string]int64
+	floats      map[string]float64
+	percentiles map[string]PercentileSource
+}
+
+func newCollectingSink() *collectingSink {
+	return &collectingSink{
+		ints:        map[string]int64{},
+		floats:      map[string]float64{},
+		percentiles: map[string]PercentileSource{},
+	}
+}
+
+func (s *collectingSink) Filter(string) bool   { return true }
+func (s *collectingSink) StartReport(Registry) {}
+func (s *collectingSink) EndReport(Registry)   {}
+func (s *collectingSink) AcceptIntMetric(name string, value int64) {
+	s.ints[name] = value
+}
+func (s *collectingSink) AcceptFloatMetric(name string, value float64) {
+	s.floats[name] = value
+}
+func (s *collectingSink) AcceptPercentileMetric(name string, value PercentileSource) {
+	s.percentiles[name] = value
+}
+
+func report(registry RegistryReader, options ...DelegatingReporterOption) *collectingSink {
+	sink := newCollectingSink()
+	NewDelegatingReporter(registry, sink, nil, options...).Report()
+	return sink
+}
+
+func TestReporterDefaultPercentiles(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Histogram("histogram").Update(10)
+
+	sink := report(registry)
+	require.Contains(t, sink.percentiles, "histogram.percentile")
+	require.Len(t, sink.floats, 1)
+}
+
+func TestReporterConfiguredPercentiles(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	histogram := registry.Histogram("histogram")
+	for i := int64(1); i <= 100; i++ {
+		histogram.Update(i)
+	}
+
+	sink := report(registry, WithPercentiles(nil, 0.5, 0.99, 0.999))
+	require.Empty(t, sink.percentiles)
+	require.Equal(t, 50.5, sink.floats["histogram.p50"])
+	require.Contains(t, sink.floats, "histogram.p99")
+	require.Contains(t, sink.floats, "histogram.p999")
+
+	sink = report(registry, WithPercentiles(PercentileNameQuantile, 0.99), WithRawPercentiles())
+	require.Contains(t, sink.floats, `histogram{quantile="0.99"}`)
+	require.Contains(t, sink.percentiles, "histogram.percentile")
+}
+
+func TestReporterFieldSelection(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Histogram("histogram").Update(10)
+	registry.Timer("timer").Update(20)
+
+	sink := report(registry)
+	require.NotContains(t, sink.floats, "histogram.std_dev")
+	require.NotContains(t, sink.ints, "timer.sum")
+	require.NotContains(t, sink.floats, "timer.rate_mean")
+
+	sink = report(registry,
+		WithMetricFields(MetricTypeHistogram, MetricNameCount, MetricNameStdDev, MetricNameVariance, MetricNameSum),
+		WithMetricFields(MetricTypeTimer, MetricNameSum, MetricNameRateMean))
+
+	require.Equal(t, int64(1), sink.ints["histogram.count"])
+	require.Contains(t, sink.floats, "histogram.std_dev")
+	require.Contains(t, sink.floats, "histogram.variance")
+	require.Equal(t, int64(10), sink.ints["histogram.sum"])
+	require.NotContains(t, sink.floats, "histogram.mean")
+	require.Empty(t, sink.percentiles)
+
+	require.Equal(t, int64(20), sink.ints["timer.sum"])
+	require.Contains(t, sink.floats, "timer.rate_mean")
+	require.NotContains(t, sink.ints, "timer.count")
+
+	// meters and timers name their mean rate the same way
+	registry.Meter("meter").Mark(1)
+	sink = report(registry, WithMetricFields(MetricTypeMeter, MetricNameRateMean))
+	require.Contains(t, sink.floats, "meter.rate_mean")
+	require.NotContains(t, sink.floats, "meter.mean")
+	require.Contains(t, report(registry).floats, "meter.rate_mean")
+}
+
+func TestDefaultMetricFields(t *testing.T) {
+	fields := DefaultMetricFields()
+	require.Contains(t, fields[MetricTypeMeter], MetricNameRateMean)
+
+	// the defaults are returned as a copy, so they can't be changed by accident
+	fields[MetricTypeMeter][0] = MetricNameSum
+	delete(fields, MetricTypeTimer)
+	require.Equal(t, MetricNameCount, DefaultMetricFields()[MetricTypeMeter][0])
+	require.Contains(t, DefaultMetricFields(), MetricTypeTimer)
+}
+
+type unitSink struct {
+	*collectingSink
+	units map[string]Unit
+}
+
+func (s *unitSink) AcceptIntMetricWithUnit(name string, value int64, unit Unit) {
+	s.AcceptIntMetric(name, value)
+	s.units[name] = unit
+}
+
+func (s *unitSink) AcceptFloatMetricWithUnit(name string, value float64, unit Unit) {
+	s.AcceptFloatMetric(name, value)
+	s.units[name] = unit
+}
+
+func (s *unitSink) AcceptPercentileMetricWithUnit(name string, value PercentileSource, unit Unit) {
+	s.AcceptPercentileMetric(name, value)
+	s.units[name] = unit
+}
+
+func TestReporterUnitConversion(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Timer("timer").Update(3 * time.Millisecond)
+	registry.Histogram("histogram", WithUnit(UnitBytes)).Update(2048)
+	registry.Meter("meter", WithUnit(UnitBytes)).Mark(10)
+
+	sink := &unitSink{collectingSink: newCollectingSink(), units: map[string]Unit{}}
+	reporter := NewDelegatingReporter(registry, sink, nil,
+		WithUnitConversion(UnitMilliseconds),
+		WithMetricFields(MetricTypeTimer, MetricNameCount, MetricNameMax, MetricNameVariance, MetricNamePercentile))
+	registry.AcceptVisitor(reporter)
+
+	require.Equal(t, 3.0, sink.floats["timer.max"])
+	require.Equal(t, UnitMilliseconds, sink.units["timer.max"])
+	require.Equal(t, UnitNone, sink.units["timer.count"])
+	require.Equal(t, Unit("ms^2"), sink.units["timer.variance"])
+	require.InDelta(t, 3.0, sink.percentiles["timer.percentile"].Percentile(0.5), 0.0001)
+
+	require.Equal(t, int64(2048), sink.ints["histogram.max"])
+	require.Equal(t, UnitBytes, sink.units["histogram.max"])
+	require.Equal(t, Unit("bytes/s"), sink.units["meter.rate_m1"])
+	require.Equal(t, Unit("bytes/s"), sink.units["meter.rate_mean"])
+
+	// timer rates count invocations, so they're in the same unit as the rates of a meter without a unit
+	registry.Meter("events").Mark(1)
+	sink = &unitSink{collectingSink: newCollectingSink(), units: map[string]Unit{}}
+	registry.AcceptVisitor(NewDelegatingReporter(registry, sink, nil))
+	require.Contains(t, sink.units, "timer.rate_m1")
+	require.Equal(t, sink.units["events.rate_m1"], sink.units["timer.rate_m1"])
+	require.Equal(t, sink.units["events.count"], sink.units["timer.count"])
+
+	// values already in the target unit are reported as they are
+	sink = &unitSink{collectingSink: newCollectingSink(), units: map[string]Unit{}}
+	registry.AcceptVisitor(NewDelegatingReporter(registry, sink, nil, WithUnitConversion(UnitBytes)))
+	require.Equal(t, int64(2048), sink.ints["histogram.max"])
+	require.NotContains(t, sink.floats, "histogram.max")
+	require.Equal(t, UnitBytes, sink.units["histogram.max"])
+}
+
+func TestReportedNameCacheBounded(t *testing.T) {
+	// reporters used as plain visitors never finish a report, so the cache has to bound itself
+	cache := newReportedNameCache()
+	for i := range 2*maxReportedNames + 1 {
+		cache.get(reportedName{kind: reportedNameSuffixed, name: "m", extra: strconv.Itoa(i)}, buildSuffixedName)
+	}
+	require.LessOrEqual(t, len(cache.current)+len(cache.previous), 2*maxReportedNames)
+	require.Equal(t, "m.0", cache.get(reportedName{kind: reportedNameSuffixed, name: "m", extra: "0"}, buildSuffixedName))
+}
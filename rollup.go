@@ -0,0 +1,399 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Aggregation selects how a Rollup combines the metrics it matches
+type Aggregation string
+
+const (
+	AggregationSum Aggregation = "sum"
+	AggregationAvg Aggregation = "avg"
+	AggregationMin Aggregation = "min"
+	AggregationMax Aggregation = "max"
+)
+
+// Rollup is a metric derived, each time it's visited, from all metrics of a given type whose names match a
+// pattern. For example, a rollup named links.tx.bytes with the pattern link.*.tx.bytes and AggregationSum
+// reports the total of all per-link meters, without recording anything twice on the hot path. Rollups are
+// visited as a metric of the type they aggregate, except that counter rollups are visited as gauges, as
+// counters are, since Visitor has no method for counters. Rollups don't match other rollups.
+//
+// Patterns are made up of '.' separated segments. A '*' segment matches any one segment, and a '**'
+// segment matches any number of segments, including none. Other segments must match exactly, except that
+// a '*' within a segment matches any run of characters, for example link-*.
+//
+// Gauges, float gauges, counters and meters support all aggregations, which are applied to each of their
+// values. Meters only keep the custom rate windows which all matched meters have. Histograms and timers
+// only support AggregationSum, which merges them as described for MergeHistograms. With AggregationSum, a
+// rollup which matches no metrics is visited with zero values, while the other aggregations aren't
+// visited at all.
+type Rollup interface {
+	Metric
+	Pattern() string
+	MetricType() MetricType
+	Aggregation() Aggregation
+}
+
+type namePattern struct {
+	segments []string
+	// prefix is the literal part of the pattern, so only names starting with it need to be checked
+	prefix string
+}
+
+func compileNamePattern(pattern string) (*namePattern, error) {
+	result := &namePattern{
+		segments: strings.Split(pattern, "."),
+	}
+	for idx, segment := range result.segments {
+		if segment == "" {
+			return nil, fmt.Errorf("%w: pattern '%v' has an empty segment", ErrInvalidRollup, pattern)
+		}
+		if strings.Contains(segment, "*") {
+			result.prefix = strings.Join(result.segments[:idx], ".")
+			if result.prefix != "" {
+				result.prefix += "."
+			}
+			return result, nil
+		}
+	}
+	result.prefix = pattern
+	return result, nil
+}
+
+func (self *namePattern) matches(name string) bool {
+	return matchSegments(self.segments, strings.Split(name, "."))
+}
+
+func matchSegments(patterns []string, segments []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for skip := 0; skip <= len(segments); skip++ {
+				if matchSegments(patterns[1:], segments[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 || !matchSegment(patterns[0], segments[0]) {
+			return false
+		}
+		patterns, segments = patterns[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// matchSegment matches a single name segment against a pattern segment, in which '*' matches any run of characters
+func matchSegment(pattern string, segment string) bool {
+	literals := strings.Split(pattern, "*")
+	if len(literals) == 1 {
+		return pattern == segment
+	}
+	if !strings.HasPrefix(segment, literals[0]) {
+		return false
+	}
+	segment = segment[len(literals[0]):]
+	for _, literal := range literals[1 : len(literals)-1] {
+		idx := strings.Index(segment, literal)
+		if idx < 0 {
+			return false
+		}
+		segment = segment[idx+len(literal):]
+	}
+	return strings.HasSuffix(segment, literals[len(literals)-1])
+}
+
+type rollupImpl struct {
+	pattern     string
+	compiled    *namePattern
+	metricType  MetricType
+	aggregation Aggregation
+	unit        Unit
+	registry    *registryImpl
+	idleTracker
+	dispose func()
+}
+
+func (self *rollupImpl) Pattern() string {
+	return self.pattern
+}
+
+func (self *rollupImpl) MetricType() MetricType {
+	return self.metricType
+}
+
+func (self *rollupImpl) Aggregation() Aggregation {
+	return self.aggregation
+}
+
+func (self *rollupImpl) Unit() Unit {
+	return self.unit
+}
+
+func (self *rollupImpl) Dispose() {
+	self.dispose()
+}
+
+// matching returns the metrics of type T which match the pattern
+func matching[T Metric](self *rollupImpl) []T {
+	var result []T
+	self.registry.eachMetricWithPrefix(self.compiled.prefix, func(name string, metric Metric) {
+		if _, isRollup := metric.(*rollupImpl); isRollup {
+			return
+		}
+		if typed, ok := metric.(T); ok && MetricTypeOf(metric) == self.metricType && self.compiled.matches(name) {
+			result = append(result, typed)
+		}
+	})
+	return result
+}
+
+// unitOf returns the unit of the rollup. If no unit was given when the rollup was created, the unit of the
+// first matching metric is used
+func (self *rollupImpl) unitOf(metrics []Metric) Unit {
+	if self.unit != UnitNone || len(metrics) == 0 {
+		return self.unit
+	}
+	return UnitOf(metrics[0])
+}
+
+func (self *rollupImpl) AcceptVisitor(name string, visitor Visitor) {
+	self.visit(name, visitor, &evaluation{registry: self.registry})
+}
+
+// visit aggregates the matching metrics and gives the result to the visitor. Matching computed gauges are
+// evaluated as part of the given evaluation, so a computed gauge which references a rollup matching it is
+// detected as a cycle, rather than being evaluated over and over
+func (self *rollupImpl) visit(name string, visitor Visitor, evaluation *evaluation) {
+	switch self.metricType {
+	case MetricTypeGauge:
+		gauges := matching[Gauge](self)
+		if value, ok := aggregateInts(self.aggregation, gauges, Gauge.Value); ok {
+			visitor.VisitGauge(name, &gaugeSnapshot{value: value, unit: self.unitOf(asMetrics(gauges))})
+		}
+	case MetricTypeCounter:
+		counters := matching[Counter](self)
+		if value, ok := aggregateInts(self.aggregation, counters, Counter.Count); ok {
+			visitor.VisitGauge(name, &gaugeSnapshot{value: value, unit: self.unitOf(asMetrics(counters))})
+		}
+	case MetricTypeGaugeFloat64:
+		gauges := matching[GaugeFloat64](self)
+		value, ok := aggregateFloats(self.aggregation, gauges, func(gauge GaugeFloat64) float64 {
+			if computed, isComputed := gauge.(*computedGauge); isComputed {
+				return evaluation.evaluateGauge(computed)
+			}
+			return gauge.Value()
+		})
+		if ok && !math.IsNaN(value) {
+			visitor.VisitGaugeFloat64(name, &gaugeFloat64Snapshot{value: value, unit: self.unitOf(asMetrics(gauges))})
+		}
+	case MetricTypeMeter:
+		if meter, ok := self.aggregateMeters(matching[Meter](self)); ok {
+			visitor.VisitMeter(name, meter)
+		}
+	case MetricTypeHistogram:
+		histograms := matching[Histogram](self)
+		if merged, err := MergeHistograms(histograms...); err != nil {
+			slog.Error("unable to merge histograms for rollup", "name", name, "pattern", self.pattern, "error", err)
+		} else {
+			visitor.VisitHistogram(name, merged)
+		}
+	case MetricTypeTimer:
+		timers := matching[Timer](self)
+		if merged, err := MergeTimers(timers...); err != nil {
+			slog.Error("unable to merge timers for rollup", "name", name, "pattern", self.pattern, "error", err)
+		} else {
+			visitor.VisitTimer(name, merged)
+		}
+	}
+}
+
+func asMetrics[T Metric](metrics []T) []Metric {
+	result := make([]Metric, len(metrics))
+	for i, metric := range metrics {
+		result[i] = metric
+	}
+	return result
+}
+
+func aggregateInts[T any](aggregation Aggregation, metrics []T, value func(T) int64) (int64, bool) {
+	if len(metrics) == 0 {
+		return 0, aggregation == AggregationSum
+	}
+	result := value(metrics[0])
+	for _, metric := range metrics[1:] {
+		v := value(metric)
+		switch aggregation {
+		case AggregationSum, AggregationAvg:
+			result += v
+		case AggregationMin:
+			result = min(result, v)
+		case AggregationMax:
+			result = max(result, v)
+		}
+	}
+	if aggregation == AggregationAvg {
+		result = int64(math.Round(float64(result) / float64(len(metrics))))
+	}
+	return result, true
+}
+
+func aggregateFloats[T any](aggregation Aggregation, metrics []T, value func(T) float64) (float64, bool) {
+	if len(metrics) == 0 {
+		return 0, aggregation == AggregationSum
+	}
+	result := value(metrics[0])
+	for _, metric := range metrics[1:] {
+		v := value(metric)
+		switch aggregation {
+		case AggregationSum, AggregationAvg:
+			result += v
+		case AggregationMin:
+			result = min(result, v)
+		case AggregationMax:
+			result = max(result, v)
+		}
+	}
+	if aggregation == AggregationAvg {
+		result /= float64(len(metrics))
+	}
+	return result, true
+}
+
+func (self *rollupImpl) aggregateMeters(meters []Meter) (Meter, bool) {
+	if len(meters) == 0 && self.aggregation != AggregationSum {
+		return nil, false
+	}
+
+	snapshot := &nativeMeterSnapshot{}
+	snapshot.count, _ = aggregateInts(self.aggregation, meters, Meter.Count)
+	snapshot.rate1, _ = aggregateFloats(self.aggregation, meters, Meter.Rate1)
+	snapshot.rate5, _ = aggregateFloats(self.aggregation, meters, Meter.Rate5)
+	snapshot.rate15, _ = aggregateFloats(self.aggregation, meters, Meter.Rate15)
+	snapshot.rateMean, _ = aggregateFloats(self.aggregation, meters, Meter.RateMean)
+
+	for idx, meter := range meters {
+		windows := RateWindows(meter)
+		if idx == 0 {
+			for _, window := range windows {
+				snapshot.windows = append(snapshot.windows, windowedRate{window: window})
+			}
+		} else {
+			snapshot.windows = slices.DeleteFunc(snapshot.windows, func(windowed windowedRate) bool {
+				return !slices.Contains(windows, windowed.window)
+			})
+		}
+	}
+	for i := range snapshot.windows {
+		window := snapshot.windows[i].window
+		snapshot.windows[i].rate, _ = aggregateFloats(self.aggregation, meters, func(meter Meter) float64 {
+			return Rate(meter, window)
+		})
+	}
+
+	return &meterSnapshot{
+		nativeMeterSnapshot: snapshot,
+		unit:                self.unitOf(asMetrics(meters)),
+	}, true
+}
+
+// meterSnapshot is a read-only Meter, used to visit values derived from other metrics
+type meterSnapshot struct {
+	*nativeMeterSnapshot
+	unit Unit
+}
+
+func (self *meterSnapshot) Unit() Unit {
+	return self.unit
+}
+
+func (self *meterSnapshot) Dispose() {}
+
+func validateRollup(pattern string, metricType MetricType, aggregation Aggregation) (*namePattern, error) {
+	switch aggregation {
+	case AggregationSum, AggregationAvg, AggregationMin, AggregationMax:
+	default:
+		return nil, fmt.Errorf("%w: unknown aggregation '%v'", ErrInvalidRollup, aggregation)
+	}
+
+	switch metricType {
+	case MetricTypeGauge, MetricTypeGaugeFloat64, MetricTypeCounter, MetricTypeMeter:
+	case MetricTypeHistogram, MetricTypeTimer:
+		if aggregation != AggregationSum {
+			return nil, fmt.Errorf("%w: %v rollups only support the %v aggregation", ErrInvalidRollup, metricType, AggregationSum)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v metrics can't be rolled up", ErrInvalidRollup, metricType)
+	}
+
+	return compileNamePattern(pattern)
+}
+
+func (registry *registryImpl) Rollup(name string, pattern string, metricType MetricType, aggregation Aggregation, options ...MetricOption) Rollup {
+	rollup, err := registry.TryRollup(name, pattern, metricType, aggregation, options...)
+	return handleCreateError(registry, name, rollup, err, newNoopRollup)
+}
+
+func (registry *registryImpl) TryRollup(name string, pattern string, metricType MetricType, aggregation Aggregation, options ...MetricOption) (Rollup, error) {
+	compiled, err := validateRollup(pattern, metricType, aggregation)
+	if err != nil {
+		return nil, err
+	}
+
+	rollup, err := getOrCreateMetric(registry, name, MetricTypeRollup, func(name string) Rollup {
+		rollup := &rollupImpl{
+			pattern:     pattern,
+			compiled:    compiled,
+			metricType:  metricType,
+			aggregation: aggregation,
+			unit:        newMetricConfig(options).unit,
+			registry:    registry,
+			idleTracker: idleTracker{exempt: true},
+		}
+		rollup.dispose = func() {
+			registry.remove(name, rollup)
+		}
+		return rollup
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rollup.Pattern() != pattern || rollup.MetricType() != metricType || rollup.Aggregation() != aggregation {
+		return nil, fmt.Errorf("%w: rollup '%v' already exists with pattern '%v', type %v and aggregation %v",
+			ErrInvalidRollup, name, rollup.Pattern(), rollup.MetricType(), rollup.Aggregation())
+	}
+	return rollup, nil
+}
+
+type noopRollup struct{}
+
+func newNoopRollup() Rollup {
+	return noopRollup{}
+}
+
+func (noopRollup) Pattern() string          { return "" }
+func (noopRollup) MetricType() MetricType   { return "" }
+func (noopRollup) Aggregation() Aggregation { return "" }
+func (noopRollup) Dispose()                 {}
@@ -0,0 +1,187 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"link.*.tx.bytes", "link.abc.tx.bytes", true},
+		{"link.*.tx.bytes", "link.tx.bytes", false},
+		{"link.*.tx.bytes", "link.a.b.tx.bytes", false},
+		{"link.**.bytes", "link.bytes", true},
+		{"link.**.bytes", "link.a.b.tx.bytes", true},
+		{"link.**", "link.a.b", true},
+		{"link.**", "links.a", false},
+		{"link-*.tx", "link-west.tx", true},
+		{"link-*.tx", "link-.tx", true},
+		{"link-*.tx", "links.tx", false},
+		{"*-link-*.tx", "us-link-west.tx", true},
+		{"*-link-*.tx", "us-west.tx", false},
+		{"link.tx", "link.tx", true},
+	}
+
+	for _, test := range tests {
+		pattern, err := compileNamePattern(test.pattern)
+		require.NoError(t, err)
+		require.Equal(t, test.matches, pattern.matches(test.name), test)
+	}
+
+	pattern, err := compileNamePattern("link.*.tx.bytes")
+	require.NoError(t, err)
+	require.Equal(t, "link.", pattern.prefix)
+
+	_, err = compileNamePattern("link..tx")
+	require.ErrorIs(t, err, ErrInvalidRollup)
+}
+
+func TestGaugeRollup(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Gauge("link.a.queue").Update(3)
+	registry.Gauge("link.b.queue").Update(7)
+	registry.Gauge("link.c.queue").Update(5)
+	registry.Gauge("link.c.other").Update(100)
+	registry.GaugeFloat64("link.d.queue").Update(100)
+
+	for aggregation, expected := range map[Aggregation]int64{
+		AggregationSum: 15,
+		AggregationAvg: 5,
+		AggregationMin: 3,
+		AggregationMax: 7,
+	} {
+		name := "links.queue." + string(aggregation)
+		rollup := registry.Rollup(name, "link.*.queue", MetricTypeGauge, aggregation)
+		require.Equal(t, MetricTypeGauge, MetricTypeOf(rollup))
+
+		visitor := newCollectingVisitor()
+		registry.AcceptVisitor(visitor)
+		require.Equal(t, expected, visitor.gauges[name].Value(), aggregation)
+	}
+}
+
+func TestMeterRollup(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	first := registry.Meter("link.a.tx.bytes", WithUnit(UnitBytes), WithRateWindows(time.Second, 10*time.Second))
+	defer first.Dispose()
+	second := registry.Meter("link.b.tx.bytes", WithUnit(UnitBytes), WithRateWindows(10*time.Second))
+	defer second.Dispose()
+	first.Mark(10)
+	second.Mark(32)
+
+	registry.Rollup("links.tx.bytes", "link.*.tx.bytes", MetricTypeMeter, AggregationSum)
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	total := visitor.meters["links.tx.bytes"]
+	require.NotNil(t, total)
+	require.Equal(t, int64(42), total.Count())
+	require.Equal(t, UnitBytes, UnitOf(total))
+	require.Equal(t, []time.Duration{10 * time.Second}, RateWindows(total))
+
+	// the rollup doesn't match itself, or other rollups
+	registry.Rollup("links.all", "**", MetricTypeMeter, AggregationMax)
+	visitor = newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, int64(32), visitor.meters["links.all"].Count())
+}
+
+func TestRollupWithoutMatches(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Rollup("total", "link.*.queue", MetricTypeGauge, AggregationSum)
+	registry.Rollup("largest", "link.*.queue", MetricTypeGauge, AggregationMax)
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Contains(t, visitor.gauges, "total")
+	require.Equal(t, int64(0), visitor.gauges["total"].Value())
+	require.NotContains(t, visitor.gauges, "largest")
+}
+
+func TestTimerRollup(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	first := registry.Timer("link.a.latency")
+	defer first.Dispose()
+	second := registry.Timer("link.b.latency")
+	defer second.Dispose()
+	first.Update(time.Millisecond)
+	second.Update(3 * time.Millisecond)
+
+	registry.Rollup("links.latency", "link.*.latency", MetricTypeTimer, AggregationSum)
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	latency := visitor.timers["links.latency"]
+	require.NotNil(t, latency)
+	require.Equal(t, int64(2), latency.Count())
+	require.Equal(t, int64(3*time.Millisecond), latency.Max())
+}
+
+func TestInvalidRollup(t *testing.T) {
+	registry := NewRegistry("test", nil)
+
+	_, err := registry.TryRollup("latency", "link.*.latency", MetricTypeTimer, AggregationAvg)
+	require.ErrorIs(t, err, ErrInvalidRollup)
+
+	_, err = registry.TryRollup("ops", "link.*.ops", MetricTypeOperation, AggregationSum)
+	require.ErrorIs(t, err, ErrInvalidRollup)
+
+	_, err = registry.TryRollup("total", "link.*.queue", MetricTypeGauge, "median")
+	require.ErrorIs(t, err, ErrInvalidRollup)
+
+	registry.Gauge("total")
+	_, err = registry.TryRollup("total", "link.*.queue", MetricTypeGauge, AggregationSum)
+	require.ErrorIs(t, err, ErrMetricTypeConflict)
+
+	existing := registry.Rollup("links", "link.*.queue", MetricTypeGauge, AggregationSum)
+	rollup, err := registry.TryRollup("links", "link.*.queue", MetricTypeGauge, AggregationSum)
+	require.NoError(t, err)
+	require.Same(t, existing, rollup)
+
+	_, err = registry.TryRollup("links", "link.*.other", MetricTypeGauge, AggregationSum)
+	require.ErrorIs(t, err, ErrInvalidRollup)
+	_, err = registry.TryRollup("links", "link.*.queue", MetricTypeCounter, AggregationSum)
+	require.ErrorIs(t, err, ErrInvalidRollup)
+	_, err = registry.TryRollup("links", "link.*.queue", MetricTypeGauge, AggregationMax)
+	require.ErrorIs(t, err, ErrInvalidRollup)
+}
+
+func TestChildRegistryRollup(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	child := registry.Child("router", nil)
+	child.Gauge("link.a.queue").Update(1)
+	child.Gauge("link.b.queue").Update(2)
+	registry.Gauge("link.c.queue").Update(100)
+
+	rollup := child.Rollup("links.queue", "link.*.queue", MetricTypeGauge, AggregationSum)
+	require.Equal(t, "router.link.*.queue", rollup.Pattern())
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, int64(3), visitor.gauges["router.links.queue"].Value())
+
+	rollup.Dispose()
+	require.False(t, registry.IsValidMetric("router.links.queue"))
+}
@@ -0,0 +1,213 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scope acquires metrics from a Registry on behalf of an owner, such as a link or a circuit, and disposes
+// all of them when it's closed. Meters, histograms, window counters, counters and operations are reference
+// counted, so closing a scope only releases its own references to them. Gauges and timers aren't reference
+// counted, so a scope only removes the gauges and timers it created, leaving those which already existed to
+// their other users. Metrics requested after the scope has been closed are no-ops.
+type Scope interface {
+	// Registry returns the registry metrics are acquired from
+	Registry() Registry
+
+	Gauge(name string, options ...MetricOption) Gauge
+	FuncGauge(name string, f func() int64, options ...MetricOption) Gauge
+	GaugeFloat64(name string, options ...MetricOption) GaugeFloat64
+	FuncGaugeFloat64(name string, f func() float64, options ...MetricOption) GaugeFloat64
+	RatioGauge(name string, numerator string, denominator string, options ...MetricOption) GaugeFloat64
+	ExpressionGauge(name string, expression string, options ...MetricOption) GaugeFloat64
+	Meter(name string, options ...MetricOption) Meter
+	Histogram(name string, options ...MetricOption) Histogram
+	Timer(name string, options ...MetricOption) Timer
+	WindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) WindowCounter
+	Counter(name string, options ...MetricOption) Counter
+	Operation(name string, options ...MetricOption) Operation
+
+	// Close disposes all metrics acquired through the scope. It's safe to call more than once
+	Close()
+
+	// IsClosed returns true if the scope has been closed
+	IsClosed() bool
+}
+
+// NewScope returns a Scope which acquires metrics from the given registry
+func NewScope(registry Registry) Scope {
+	return &scopeImpl{
+		registry: registry,
+	}
+}
+
+// NewScopeWithContext returns a Scope which acquires metrics from the given registry, and which is closed
+// when the given context is cancelled
+func NewScopeWithContext(ctx context.Context, registry Registry) Scope {
+	result := &scopeImpl{
+		registry: registry,
+	}
+	result.stopAfterFunc = context.AfterFunc(ctx, result.Close)
+	return result
+}
+
+type scopeImpl struct {
+	registry      Registry
+	lock          sync.Mutex
+	metrics       []Metric
+	closed        bool
+	stopAfterFunc func() bool
+}
+
+// acquire records the given metric so it's disposed when the scope is closed. If the scope has already
+// been closed, the metric isn't acquired and the no-op metric is returned instead
+func acquire[T Metric](scope *scopeImpl, get func() T, noop func() T) T {
+	scope.lock.Lock()
+	defer scope.lock.Unlock()
+
+	if scope.closed {
+		return noop()
+	}
+
+	metric := get()
+	scope.metrics = append(scope.metrics, metric)
+	return metric
+}
+
+// acquireCreated is acquire for metrics which aren't reference counted. The metric is only disposed when the
+// scope is closed if it didn't already exist in the registry, as disposing it would remove it for all of its
+// users. A metric created by another user at the same time may still be taken as created by the scope
+func acquireCreated[T Metric](scope *scopeImpl, name string, get func() T, noop func() T) T {
+	scope.lock.Lock()
+	defer scope.lock.Unlock()
+
+	if scope.closed {
+		return noop()
+	}
+
+	existed := scope.registry.IsValidMetric(name)
+	metric := get()
+	if !existed {
+		scope.metrics = append(scope.metrics, metric)
+	}
+	return metric
+}
+
+func (self *scopeImpl) Registry() Registry {
+	return self.registry
+}
+
+func (self *scopeImpl) Gauge(name string, options ...MetricOption) Gauge {
+	return acquireCreated(self, name, func() Gauge {
+		return self.registry.Gauge(name, options...)
+	}, newNoopGauge)
+}
+
+func (self *scopeImpl) FuncGauge(name string, f func() int64, options ...MetricOption) Gauge {
+	return acquireCreated(self, name, func() Gauge {
+		return self.registry.FuncGauge(name, f, options...)
+	}, newNoopGauge)
+}
+
+func (self *scopeImpl) GaugeFloat64(name string, options ...MetricOption) GaugeFloat64 {
+	return acquireCreated(self, name, func() GaugeFloat64 {
+		return self.registry.GaugeFloat64(name, options...)
+	}, newNoopGaugeFloat64)
+}
+
+func (self *scopeImpl) FuncGaugeFloat64(name string, f func() float64, options ...MetricOption) GaugeFloat64 {
+	return acquireCreated(self, name, func() GaugeFloat64 {
+		return self.registry.FuncGaugeFloat64(name, f, options...)
+	}, newNoopGaugeFloat64)
+}
+
+func (self *scopeImpl) RatioGauge(name string, numerator string, denominator string, options ...MetricOption) GaugeFloat64 {
+	return acquireCreated(self, name, func() GaugeFloat64 {
+		return self.registry.RatioGauge(name, numerator, denominator, options...)
+	}, newNoopGaugeFloat64)
+}
+
+func (self *scopeImpl) ExpressionGauge(name string, expression string, options ...MetricOption) GaugeFloat64 {
+	return acquireCreated(self, name, func() GaugeFloat64 {
+		return self.registry.ExpressionGauge(name, expression, options...)
+	}, newNoopGaugeFloat64)
+}
+
+func (self *scopeImpl) Meter(name string, options ...MetricOption) Meter {
+	return acquire(self, func() Meter {
+		return self.registry.Meter(name, options...)
+	}, newNoopMeter)
+}
+
+func (self *scopeImpl) Histogram(name string, options ...MetricOption) Histogram {
+	return acquire(self, func() Histogram {
+		return self.registry.Histogram(name, options...)
+	}, newNoopHistogram)
+}
+
+func (self *scopeImpl) Timer(name string, options ...MetricOption) Timer {
+	return acquireCreated(self, name, func() Timer {
+		return self.registry.Timer(name, options...)
+	}, newNoopTimer)
+}
+
+func (self *scopeImpl) WindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) WindowCounter {
+	return acquire(self, func() WindowCounter {
+		return self.registry.WindowCounter(name, window, buckets, options...)
+	}, newNoopWindowCounter)
+}
+
+func (self *scopeImpl) Counter(name string, options ...MetricOption) Counter {
+	return acquire(self, func() Counter {
+		return self.registry.Counter(name, options...)
+	}, newNoopCounter)
+}
+
+func (self *scopeImpl) Operation(name string, options ...MetricOption) Operation {
+	return acquire(self, func() Operation {
+		return self.registry.Operation(name, options...)
+	}, newNoopOperation)
+}
+
+func (self *scopeImpl) Close() {
+	self.lock.Lock()
+	if self.closed {
+		self.lock.Unlock()
+		return
+	}
+	self.closed = true
+	metrics := self.metrics
+	self.metrics = nil
+	self.lock.Unlock()
+
+	if self.stopAfterFunc != nil {
+		self.stopAfterFunc()
+	}
+
+	for _, metric := range metrics {
+		metric.Dispose()
+	}
+}
+
+func (self *scopeImpl) IsClosed() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.closed
+}
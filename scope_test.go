@@ -0,0 +1,87 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeClose(t *testing.T) {
+	registry := NewRegistry("test", nil, WithRefCountTracking(0))
+	shared := registry.Meter("shared")
+
+	scope := NewScope(registry)
+	scope.Meter("shared").Mark(1)
+	scope.Meter("link.rx").Mark(1)
+	scope.Histogram("link.latency").Update(1)
+	scope.Timer("link.timer").Update(time.Millisecond)
+	scope.Timer("link.timer")
+	scope.Gauge("link.queue").Update(1)
+
+	scope.Close()
+	scope.Close()
+	require.True(t, scope.IsClosed())
+
+	require.True(t, registry.IsValidMetric("shared"))
+	require.Equal(t, int64(1), registry.GetMeter("shared").Count())
+	for _, name := range []string{"link.rx", "link.latency", "link.timer", "link.queue"} {
+		require.False(t, registry.IsValidMetric(name), name)
+	}
+
+	// metrics requested after close aren't stored
+	scope.Meter("link.late").Mark(1)
+	require.False(t, registry.IsValidMetric("link.late"))
+
+	shared.Dispose()
+	AssertNoLeakedMetrics(t, registry)
+}
+
+func TestScopeLeavesSharedMetrics(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("shared.timer")
+	gauge := registry.Gauge("shared.gauge")
+
+	scope := NewScope(registry)
+	require.Same(t, timer, scope.Timer("shared.timer"))
+	require.Same(t, gauge, scope.Gauge("shared.gauge"))
+	scope.Timer("scoped.timer")
+	scope.Close()
+
+	// gauges and timers which existed before the scope acquired them are left to their other users
+	require.Same(t, timer, registry.GetTimer("shared.timer"))
+	require.Same(t, gauge, registry.GetGauge("shared.gauge"))
+	require.False(t, registry.IsValidMetric("scoped.timer"))
+}
+
+func TestScopeContext(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	scope := NewScopeWithContext(ctx, registry)
+	scope.Meter("meter")
+	require.True(t, registry.IsValidMetric("meter"))
+
+	cancel()
+	require.Eventually(t, func() bool {
+		return !registry.IsValidMetric("meter")
+	}, time.Second, time.Millisecond)
+	require.True(t, scope.IsClosed())
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+)
+
+// WithSharding makes a meter count events using a striped counter, which spreads updates over several
+// cache lines, so a meter marked from many goroutines at once, for example once per packet, doesn't
+// contend on a single atomic. Reading the count is more expensive. Sharded meters are native meters,
+// see WithNativeMeters. The option has no effect on other metric types; Counters are always sharded
+func WithSharding() MetricOption {
+	return func(config *metricConfig) {
+		config.sharded = true
+	}
+}
+
+// maxStripes bounds the memory used by each striped counter
+const maxStripes = 64
+
+// stripedCounter spreads additions over several cells, each on its own cache line, so goroutines on
+// different CPUs rarely contend on the same memory. Cells are picked at random, which is cheap and lock-free
+// in math/rand/v2. Reading sums the cells, so reads are more expensive than with a single atomic.
+type stripedCounter struct {
+	cells []stripedCell
+	mask  uint32
+}
+
+type stripedCell struct {
+	atomic.Int64
+	// pad the cell to 128 bytes, which covers adjacent cache line prefetching
+	_ [120]byte
+}
+
+func newStripedCounter() *stripedCounter {
+	stripes := min(maxStripes, 1<<bits.Len(uint(runtime.GOMAXPROCS(0)-1)))
+	return &stripedCounter{
+		cells: make([]stripedCell, stripes),
+		mask:  uint32(stripes - 1),
+	}
+}
+
+func (self *stripedCounter) Add(n int64) {
+	self.cells[rand.Uint32()&self.mask].Add(n)
+}
+
+func (self *stripedCounter) Load() int64 {
+	var result int64
+	for i := range self.cells {
+		result += self.cells[i].Load()
+	}
+	return result
+}
+
+// Clear zeroes the counter. Additions made concurrently with Clear may or may not be kept
+func (self *stripedCounter) Clear() {
+	for i := range self.cells {
+		self.cells[i].Store(0)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripedCounter(t *testing.T) {
+	counter := newStripedCounter()
+	require.Equal(t, 0, len(counter.cells)&(len(counter.cells)-1), "cell count must be a power of two")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				counter.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(80000), counter.Load())
+
+	counter.Clear()
+	require.Equal(t, int64(0), counter.Load())
+}
+
+func TestRegistryCounter(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	counter := registry.Counter("packets", WithUnit(UnitBytes))
+	counter.Inc(10)
+	counter.Dec(3)
+	require.Equal(t, int64(7), counter.Count())
+	require.Equal(t, MetricTypeCounter, MetricTypeOf(counter))
+
+	_, err := registry.TryMeter("packets")
+	require.ErrorIs(t, err, ErrMetricTypeConflict)
+
+	sink := report(registry)
+	require.Equal(t, int64(7), sink.ints["packets"])
+
+	meter := registry.Meter("sharded", WithSharding())
+	meter.Mark(4)
+	meter.Mark(5)
+	require.Equal(t, int64(9), meter.Count())
+	require.NotNil(t, meter.(*meterImpl).Meter.(*nativeMeter).striped)
+
+	counter.Dispose()
+	meter.Dispose()
+	require.False(t, registry.IsValidMetric("packets"))
+}
+
+func BenchmarkCounterParallel(b *testing.B) {
+	b.Run("atomic", func(b *testing.B) {
+		counter := &atomic.Int64{}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				counter.Add(1)
+			}
+		})
+	})
+
+	b.Run("striped", func(b *testing.B) {
+		counter := newStripedCounter()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				counter.Add(1)
+			}
+		})
+	})
+
+	b.Run("sharded-meter", func(b *testing.B) {
+		meter := newShardedNativeMeter()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				meter.Mark(1)
+			}
+		})
+	})
+}
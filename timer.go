@@ -1,8 +1,20 @@
 package metrics
 
 import (
-	"github.com/rcrowley/go-metrics"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// TimerNameSuccess is appended to the name of a timer to name the sub-timer recording calls to TimeErr
+	// which succeeded
+	TimerNameSuccess = "success"
+	// TimerNameFailure is appended to the name of a timer to name the sub-timer recording calls to TimeErr
+	// which returned an error
+	TimerNameFailure = "failure"
 )
 
 type Timer interface {
@@ -29,7 +41,78 @@ type Timer interface {
 
 type timerImpl struct {
 	metrics.Timer
-	dispose func()
+	name        string
+	registry    *registryImpl
+	rateWindows []time.Duration
+	idleTracker
+
+	// outcomes is set once the timer has been registered, and cleared when it's stopped
+	outcomes     atomic.Pointer[timerOutcomes]
+	outcomesLock sync.Mutex
+	stopped      bool
+}
+
+// timerOutcomes holds the sub-timers used by TimeErr
+type timerOutcomes struct {
+	success Timer
+	failure Timer
+}
+
+func (t *timerImpl) Time(f func()) {
+	t.markUpdated()
+	t.Timer.Time(f)
+}
+
+// TimeErr times the given function, returning its error. The duration is recorded by the timer and,
+// depending on whether the function returned an error, by one of the sub-timers <name>.success and
+// <name>.failure, which are created along with the timer and held until the timer is disposed
+func (t *timerImpl) TimeErr(f func() error) error {
+	start := time.Now()
+	err := f()
+	elapsed := time.Since(start)
+
+	t.Update(elapsed)
+	if outcomes := t.outcomes.Load(); outcomes != nil {
+		if err == nil {
+			outcomes.success.Update(elapsed)
+		} else {
+			outcomes.failure.Update(elapsed)
+		}
+	}
+	return err
+}
+
+// createOutcomes creates the sub-timers used by TimeErr, once the timer has been registered. A sub-timer
+// which can't be created, for instance because its name is taken by another type of metric, is replaced
+// by a no-op timer, so TimeErr never fails
+func (t *timerImpl) createOutcomes() {
+	outcomes := &timerOutcomes{
+		success: t.registry.outcomeTimer(t.name+"."+TimerNameSuccess, t.rateWindows),
+		failure: t.registry.outcomeTimer(t.name+"."+TimerNameFailure, t.rateWindows),
+	}
+
+	t.outcomesLock.Lock()
+	stopped := t.stopped
+	if !stopped {
+		t.outcomes.Store(outcomes)
+	}
+	t.outcomesLock.Unlock()
+
+	if stopped {
+		// the timer was removed while the sub-timers were being created, so release them right away
+		outcomes.success.Dispose()
+		outcomes.failure.Dispose()
+	}
+}
+
+func (t *timerImpl) Update(d time.Duration) {
+	t.markUpdated()
+	t.Timer.Update(d)
+}
+
+func (t *timerImpl) UpdateSince(ts time.Time) {
+	t.markUpdated()
+	t.Timer.UpdateSince(ts)
 }
 
 func (t *timerImpl) CreateSnapshot() Timer {
@@ -38,18 +121,125 @@ func (t *timerImpl) CreateSnapshot() Timer {
 	}
 }
 
+// Unit returns UnitNanoseconds, as timers record durations in nanoseconds
+func (t *timerImpl) Unit() Unit {
+	return UnitNanoseconds
+}
+
+func (t *timerImpl) Rate(window time.Duration) float64 {
+	return rateOf(t.Timer, window)
+}
+
+func (t *timerImpl) RateWindows() []time.Duration {
+	return rateWindowsOf(t.Timer)
+}
+
+// Dispose removes the timer from the registry and stops it. Timers aren't reference counted, so this
+// affects every user of the timer
 func (t *timerImpl) Dispose() {
+	t.registry.remove(t.name, t)
+	t.stop()
+}
+
+func (t *timerImpl) stop() {
 	t.Stop()
-	t.dispose()
+
+	t.outcomesLock.Lock()
+	outcomes := t.outcomes.Swap(nil)
+	t.stopped = true
+	t.outcomesLock.Unlock()
+
+	if outcomes != nil {
+		outcomes.success.Dispose()
+		outcomes.failure.Dispose()
+	}
 }
 
 type timerSnapshot struct {
 	metrics.Timer
 }
 
+func (t *timerSnapshot) Unit() Unit {
+	return UnitNanoseconds
+}
+
+func (t *timerSnapshot) Rate(window time.Duration) float64 {
+	return rateOf(t.Timer, window)
+}
+
+func (t *timerSnapshot) RateWindows() []time.Duration {
+	return rateWindowsOf(t.Timer)
+}
+
 func (t *timerSnapshot) Dispose() {
 }
 
 func (t *timerSnapshot) CreateSnapshot() Timer {
 	return t
 }
+
+// Stopwatch measures elapsed time for a Timer, so callers don't need to track start times themselves.
+// StartStopwatch is inlined, so a stopwatch which doesn't outlive the function using it doesn't allocate.
+// A stopwatch should only be used by one goroutine. A typical use is
+//
+//	defer metrics.StartStopwatch(timer).Stop()
+type Stopwatch struct {
+	timer Timer
+	start time.Time
+}
+
+// StartStopwatch returns a running Stopwatch which records into the given timer
+func StartStopwatch(timer Timer) *Stopwatch {
+	return &Stopwatch{
+		timer: timer,
+		start: time.Now(),
+	}
+}
+
+// Stop records the time elapsed since the stopwatch was started, or since the last lap, and returns it.
+// Stop should only be called once
+func (self *Stopwatch) Stop() time.Duration {
+	elapsed := time.Since(self.start)
+	self.timer.Update(elapsed)
+	return elapsed
+}
+
+// Lap records the time elapsed since the stopwatch was started, or since the last lap, and returns it. The
+// stopwatch keeps running, measuring the next lap, so each step of a multi-step operation can be recorded
+func (self *Stopwatch) Lap() time.Duration {
+	now := time.Now()
+	elapsed := now.Sub(self.start)
+	self.start = now
+	self.timer.Update(elapsed)
+	return elapsed
+}
+
+// OutcomeTimer may be implemented by a Timer which records the outcome of timed functions, in addition to
+// their duration. The timers created by a registry implement it, recording outcomes in the sub-timers
+// <name>.success and <name>.failure, which are created along with the timer and held until it's disposed
+type OutcomeTimer interface {
+	TimeErr(func() error) error
+}
+
+// TimeErr times the given function using the given timer, returning the function's error. If the timer
+// implements OutcomeTimer, the outcome is recorded as well
+func TimeErr(timer Timer, f func() error) error {
+	if outcomeTimer, ok := timer.(OutcomeTimer); ok {
+		return outcomeTimer.TimeErr(f)
+	}
+	start := time.Now()
+	err := f()
+	timer.UpdateSince(start)
+	return err
+}
+
+// TimeValue times a function which returns a value, recording the duration with TimeErr
+func TimeValue[T any](timer Timer, f func() (T, error)) (T, error) {
+	var result T
+	err := TimeErr(timer, func() error {
+		var err error
+		result, err = f()
+		return err
+	})
+	return result, err
+}
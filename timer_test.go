@@ -0,0 +1,155 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopwatch(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("timer")
+	defer timer.Dispose()
+
+	stopwatch := StartStopwatch(timer)
+	time.Sleep(2 * time.Millisecond)
+	lap := stopwatch.Lap()
+	require.GreaterOrEqual(t, lap, 2*time.Millisecond)
+	require.Equal(t, int64(1), timer.Count())
+
+	elapsed := stopwatch.Stop()
+	require.Less(t, elapsed, lap+time.Second)
+	require.Equal(t, int64(2), timer.Count())
+	require.Equal(t, int64(lap), timer.Max())
+
+	func() {
+		defer StartStopwatch(timer).Stop()
+	}()
+	require.Equal(t, int64(3), timer.Count())
+}
+
+func TestTimeErr(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("timer")
+
+	require.NoError(t, TimeErr(timer, func() error { return nil }))
+	failure := errors.New("failed")
+	require.ErrorIs(t, TimeErr(timer, func() error { return failure }), failure)
+	require.ErrorIs(t, TimeErr(timer, func() error { return failure }), failure)
+
+	require.Equal(t, int64(3), timer.Count())
+	require.Equal(t, int64(1), registry.GetTimer("timer.success").Count())
+	require.Equal(t, int64(2), registry.GetTimer("timer.failure").Count())
+
+	// the sub-timers are released along with the timer
+	timer.Dispose()
+	require.False(t, registry.IsValidMetric("timer"))
+	require.False(t, registry.IsValidMetric("timer.success"))
+	require.False(t, registry.IsValidMetric("timer.failure"))
+}
+
+func TestTimeErrOutcomeConflict(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	registry.Gauge("timer.failure")
+	timer := registry.Timer("timer")
+	require.NotNil(t, registry.GetTimer("timer.success"))
+
+	// the failure sub-timer can't be created, so failures are only recorded by the timer
+	require.NotPanics(t, func() {
+		_ = TimeErr(timer, func() error { return errors.New("failed") })
+	})
+	require.Equal(t, int64(1), timer.Count())
+	require.NotNil(t, registry.GetGauge("timer.failure"))
+}
+
+func TestStopwatchDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts differ under the race detector")
+	}
+	timer := newNoopTimer()
+	allocs := testing.AllocsPerRun(100, func() {
+		stopwatch := StartStopwatch(timer)
+		stopwatch.Lap()
+		stopwatch.Stop()
+	})
+	require.Zero(t, allocs)
+}
+
+func TestTimeErrAfterRemoval(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("timer")
+	registry.DisposeAll()
+
+	require.NoError(t, TimeErr(timer, func() error { return nil }))
+	require.False(t, registry.IsValidMetric("timer.success"))
+}
+
+func TestTimeValue(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("timer")
+	defer timer.Dispose()
+
+	value, err := TimeValue(timer, func() (int, error) {
+		return strconv.Atoi("42")
+	})
+	require.NoError(t, err)
+	require.Equal(t, 42, value)
+
+	_, err = TimeValue(timer, func() (int, error) {
+		return strconv.Atoi("forty-two")
+	})
+	require.Error(t, err)
+
+	require.Equal(t, int64(2), timer.Count())
+	require.Equal(t, int64(1), registry.GetTimer("timer.success").Count())
+	require.Equal(t, int64(1), registry.GetTimer("timer.failure").Count())
+}
+
+func TestTimeErrWithoutOutcomes(t *testing.T) {
+	timer := &timerAdapter{Timer: metrics.NewTimer()}
+	_, isOutcomeTimer := Timer(timer).(OutcomeTimer)
+	require.False(t, isOutcomeTimer)
+
+	failure := errors.New("failed")
+	require.ErrorIs(t, TimeErr(timer, func() error { return failure }), failure)
+	require.Equal(t, int64(1), timer.Count())
+}
+
+func TestNoopTimerConvenience(t *testing.T) {
+	timer := newNoopTimer()
+	StartStopwatch(timer).Stop()
+
+	value, err := TimeValue(timer, func() (string, error) { return "ok", nil })
+	require.NoError(t, err)
+	require.Equal(t, "ok", value)
+}
+
+func TestTimerIsNotReferenceCounted(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	timer := registry.Timer("t")
+	require.Same(t, timer, registry.Timer("t"))
+	require.Same(t, timer, registry.Timer("t"))
+
+	timer.Dispose()
+	require.False(t, registry.IsValidMetric("t"))
+}
@@ -0,0 +1,100 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+// Unit describes what the values recorded by a metric measure
+type Unit string
+
+const (
+	UnitNone         Unit = ""
+	UnitNanoseconds  Unit = "ns"
+	UnitMicroseconds Unit = "us"
+	UnitMilliseconds Unit = "ms"
+	UnitSeconds      Unit = "s"
+	UnitBytes        Unit = "bytes"
+	UnitKilobytes    Unit = "kilobytes"
+	UnitMegabytes    Unit = "megabytes"
+	UnitGigabytes    Unit = "gigabytes"
+	UnitPercent      Unit = "percent"
+)
+
+// UnitMetric is implemented by metrics which know the unit of the values they record. All metrics created
+// by a registry implement it, as do their snapshots
+type UnitMetric interface {
+	Unit() Unit
+}
+
+// UnitOf returns the unit of the given metric, or UnitNone if it doesn't implement UnitMetric
+func UnitOf(metric Metric) Unit {
+	if unitMetric, ok := metric.(UnitMetric); ok {
+		return unitMetric.Unit()
+	}
+	return UnitNone
+}
+
+type unitDimension int
+
+const (
+	dimensionTime unitDimension = iota + 1
+	dimensionData
+)
+
+type unitScale struct {
+	dimension unitDimension
+	scale     float64
+}
+
+var unitScales = map[Unit]unitScale{
+	UnitNanoseconds:  {dimension: dimensionTime, scale: 1},
+	UnitMicroseconds: {dimension: dimensionTime, scale: 1e3},
+	UnitMilliseconds: {dimension: dimensionTime, scale: 1e6},
+	UnitSeconds:      {dimension: dimensionTime, scale: 1e9},
+	UnitBytes:        {dimension: dimensionData, scale: 1},
+	UnitKilobytes:    {dimension: dimensionData, scale: 1e3},
+	UnitMegabytes:    {dimension: dimensionData, scale: 1e6},
+	UnitGigabytes:    {dimension: dimensionData, scale: 1e9},
+}
+
+// ConversionFactor returns the factor values in this unit must be multiplied by to express them in the
+// target unit. Returns false if the units measure different things and so can't be converted
+func (self Unit) ConversionFactor(target Unit) (float64, bool) {
+	from, ok := unitScales[self]
+	if !ok {
+		return 0, false
+	}
+	to, ok := unitScales[target]
+	if !ok || from.dimension != to.dimension {
+		return 0, false
+	}
+	return from.scale / to.scale, true
+}
+
+// PerSecond returns the unit of a rate of this unit, for example bytes/s
+func (self Unit) PerSecond() Unit {
+	if self == UnitNone {
+		return UnitNone
+	}
+	return self + "/s"
+}
+
+// Squared returns the unit of a variance of values in this unit
+func (self Unit) Squared() Unit {
+	if self == UnitNone {
+		return UnitNone
+	}
+	return self + "^2"
+}
@@ -0,0 +1,201 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WindowCounter counts events over a sliding time window, for example to answer how many failures
+// happened in the last minute. Unlike a Meter's rates, there's no exponential decay: events are counted
+// exactly in fixed time buckets, and drop out of the window once their bucket is older than the window.
+// More buckets make the window slide more smoothly. With a single bucket, the window doesn't slide at all:
+// it's a tumbling window, whose count starts again from zero each time a window's worth of time has passed.
+type WindowCounter interface {
+	Metric
+	// Add records n events
+	Add(n int64)
+	// Total returns the number of events in the window
+	Total() int64
+	// Rate returns the number of events in the window per second
+	Rate() float64
+	// Window returns the length of the window
+	Window() time.Duration
+}
+
+// WindowCounterVisitor may be implemented by a Visitor which handles window counters natively. Visitors
+// which don't implement it are given a window counter as a gauge holding its total, named
+// <name>.count, and a float gauge holding its rate, named using RateWindowName, for example <name>.rate_1m
+type WindowCounterVisitor interface {
+	VisitWindowCounter(name string, counter WindowCounter)
+}
+
+func visitWindowCounter(name string, counter WindowCounter, visitor Visitor) {
+	if windowVisitor, ok := visitor.(WindowCounterVisitor); ok {
+		windowVisitor.VisitWindowCounter(name, counter)
+		return
+	}
+	unit := UnitOf(counter)
+	visitor.VisitGauge(name+"."+MetricNameCount, &gaugeSnapshot{value: counter.Total(), unit: unit})
+	visitor.VisitGaugeFloat64(name+"."+RateWindowName(counter.Window()), &gaugeFloat64Snapshot{value: counter.Rate(), unit: unit.PerSecond()})
+}
+
+// newWindowCounter returns a counter over the given window, which must have been checked by validateWindow
+func newWindowCounter(window time.Duration, buckets int, clock func() int64) *windowCounter {
+	return &windowCounter{
+		window:      window,
+		bucketWidth: int64(window) / int64(buckets),
+		buckets:     make([]windowBucket, buckets),
+		clock:       clock,
+	}
+}
+
+// windowCounter keeps counts in a ring of buckets, each covering window/len(buckets). A bucket is reset
+// when it's reused for a later period. The window is made up of the current, partially elapsed, bucket and
+// the ones before it, so it covers between window - bucketWidth and window of time.
+type windowCounter struct {
+	sync.Mutex
+	window      time.Duration
+	bucketWidth int64
+	buckets     []windowBucket
+	clock       func() int64
+}
+
+type windowBucket struct {
+	period int64
+	count  int64
+}
+
+func (self *windowCounter) Add(n int64) {
+	period := self.clock() / self.bucketWidth
+
+	self.Lock()
+	defer self.Unlock()
+
+	bucket := &self.buckets[period%int64(len(self.buckets))]
+	if bucket.period != period {
+		bucket.period = period
+		bucket.count = 0
+	}
+	bucket.count += n
+}
+
+func (self *windowCounter) Total() int64 {
+	period := self.clock() / self.bucketWidth
+	oldest := period - int64(len(self.buckets)) + 1
+
+	self.Lock()
+	defer self.Unlock()
+
+	var result int64
+	for _, bucket := range self.buckets {
+		if bucket.period >= oldest && bucket.period <= period {
+			result += bucket.count
+		}
+	}
+	return result
+}
+
+func (self *windowCounter) Rate() float64 {
+	return float64(self.Total()) / self.window.Seconds()
+}
+
+func (self *windowCounter) Window() time.Duration {
+	return self.window
+}
+
+type windowCounterImpl struct {
+	*windowCounter
+	name     string
+	unit     Unit
+	registry *registryImpl
+	refCount
+	idleTracker
+}
+
+func (self *windowCounterImpl) Add(n int64) {
+	self.markUpdated()
+	self.windowCounter.Add(n)
+}
+
+func (self *windowCounterImpl) Name() string {
+	return self.name
+}
+
+func (self *windowCounterImpl) Unit() Unit {
+	return self.unit
+}
+
+func (self *windowCounterImpl) Dispose() {
+	self.registry.disposeRefCounted(self)
+}
+
+func (self *windowCounterImpl) stop() {
+	// no resources to cleanup
+}
+
+func (self *windowCounterImpl) AcceptVisitor(name string, visitor Visitor) {
+	visitWindowCounter(name, self, visitor)
+}
+
+func (registry *registryImpl) WindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) WindowCounter {
+	counter, err := registry.TryWindowCounter(name, window, buckets, options...)
+	return handleCreateError(registry, name, counter, err, newNoopWindowCounter)
+}
+
+func (registry *registryImpl) TryWindowCounter(name string, window time.Duration, buckets int, options ...MetricOption) (WindowCounter, error) {
+	if err := validateWindow(window, buckets); err != nil {
+		return nil, err
+	}
+	return getOrCreateRefCounted[WindowCounter](registry, name, MetricTypeWindowCounter, func(name string) refCounted {
+		return &windowCounterImpl{
+			windowCounter: newWindowCounter(window, buckets, nanotime),
+			name:          name,
+			unit:          newMetricConfig(options).unit,
+			registry:      registry,
+		}
+	})
+}
+
+// validateWindow checks that the window is positive and that each of its buckets covers at least a nanosecond
+func validateWindow(window time.Duration, buckets int) error {
+	if window <= 0 {
+		return fmt.Errorf("%w: window %v isn't positive", ErrInvalidWindow, window)
+	}
+	if buckets < 1 {
+		return fmt.Errorf("%w: %v buckets, at least one is required", ErrInvalidWindow, buckets)
+	}
+	if int64(window)/int64(buckets) == 0 {
+		return fmt.Errorf("%w: window %v is too short for %v buckets", ErrInvalidWindow, window, buckets)
+	}
+	return nil
+}
+
+type noopWindowCounter struct{}
+
+func newNoopWindowCounter() WindowCounter {
+	return noopWindowCounter{}
+}
+
+func (noopWindowCounter) Add(int64)             {}
+func (noopWindowCounter) Total() int64          { return 0 }
+func (noopWindowCounter) Rate() float64         { return 0 }
+func (noopWindowCounter) Window() time.Duration { return 0 }
+func (noopWindowCounter) Unit() Unit            { return UnitNone }
+func (noopWindowCounter) Dispose()              {}
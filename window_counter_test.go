@@ -0,0 +1,104 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowCounter(t *testing.T) {
+	clock := &fakeClock{now: time.Now().Truncate(time.Second).UnixNano()}
+	counter := newWindowCounter(time.Minute, 60, clock.nanotime)
+
+	for i := 0; i < 60; i++ {
+		counter.Add(2)
+		clock.advance(time.Second)
+	}
+	// the oldest second has dropped out of the window
+	require.Equal(t, int64(118), counter.Total())
+	require.InDelta(t, 118.0/60, counter.Rate(), 0.0001)
+
+	counter.Add(5)
+	require.Equal(t, int64(123), counter.Total())
+
+	clock.advance(30 * time.Second)
+	require.Equal(t, int64(29*2+5), counter.Total())
+
+	clock.advance(time.Hour)
+	require.Equal(t, int64(0), counter.Total())
+	counter.Add(1)
+	require.Equal(t, int64(1), counter.Total())
+}
+
+func TestTumblingWindowCounter(t *testing.T) {
+	clock := &fakeClock{now: time.Now().Truncate(time.Minute).UnixNano()}
+	counter := newWindowCounter(time.Minute, 1, clock.nanotime)
+
+	counter.Add(2)
+	clock.advance(59 * time.Second)
+	counter.Add(3)
+	require.Equal(t, int64(5), counter.Total())
+
+	// with a single bucket, the whole count drops out at once when the next window starts
+	clock.advance(time.Second)
+	require.Equal(t, int64(0), counter.Total())
+}
+
+func TestWindowCounterVisit(t *testing.T) {
+	registry := NewRegistry("test", nil)
+	counter := registry.WindowCounter("failures", time.Minute, 60)
+	counter.Add(3)
+	require.Same(t, counter, registry.WindowCounter("failures", time.Minute, 60))
+	require.Equal(t, MetricTypeWindowCounter, MetricTypeOf(counter))
+
+	sink := report(registry)
+	require.Equal(t, int64(3), sink.ints["failures.count"])
+	require.InDelta(t, 0.05, sink.floats["failures.rate_1m"], 0.0001)
+
+	visitor := newCollectingVisitor()
+	registry.AcceptVisitor(visitor)
+	require.Equal(t, int64(3), visitor.gauges["failures.count"].Value())
+	require.InDelta(t, 0.05, visitor.floatGauge["failures.rate_1m"].Value(), 0.0001)
+
+	counter.Dispose()
+	require.True(t, registry.IsValidMetric("failures"))
+	counter.Dispose()
+	require.False(t, registry.IsValidMetric("failures"))
+}
+
+func TestInvalidWindowCounter(t *testing.T) {
+	registry := NewRegistry("test", nil)
+
+	for _, test := range []struct {
+		window  time.Duration
+		buckets int
+	}{
+		{0, 10},
+		{-time.Second, 10},
+		{time.Second, 0},
+		{5 * time.Nanosecond, 10},
+	} {
+		_, err := registry.TryWindowCounter("window", test.window, test.buckets)
+		require.ErrorIs(t, err, ErrInvalidWindow, test)
+	}
+	require.False(t, registry.IsValidMetric("window"))
+
+	require.Panics(t, func() { registry.WindowCounter("window", 5*time.Nanosecond, 10) })
+}